@@ -25,13 +25,14 @@ $cfg = $input->Config;
 $data_source_name = "mysql:host={$cfg->Addr};dbname={$cfg->Database};charset=utf8mb4";
 
 // connect creates a PDO connection with the details given in the request.
-function connect() {
+// Connections that change session variables shouldn't be persistent.
+function connect($persistent = true) {
     global $cfg, $data_source_name;
     $options = [
         PDO::ATTR_ERRMODE            => PDO::ERRMODE_EXCEPTION,
         PDO::ATTR_DEFAULT_FETCH_MODE => PDO::FETCH_NUM,
         PDO::ATTR_EMULATE_PREPARES   => false,
-        PDO::ATTR_PERSISTENT         => true
+        PDO::ATTR_PERSISTENT         => $persistent
     ];
     return new PDO($data_source_name, $cfg->User, $cfg->Pwd, $options);
 }
@@ -152,11 +153,15 @@ switch($input->Cmd) {
     // Dumps database directly to php://output
     case 'dump':
         try {
+            $settings = [];
+            if(!empty($input->Tables)) {
+                $settings['include-tables'] = $input->Tables;
+            }
             $dump = new Mysqldump(
                 $data_source_name,
                 $cfg->User,
                 $cfg->Pwd,
-                [],
+                $settings,
                 [
                     PDO::ATTR_PERSISTENT => false,
                     PDO::ATTR_DEFAULT_FETCH_MODE => PDO::FETCH_BOTH,
@@ -168,4 +173,25 @@ switch($input->Cmd) {
             echo json_encode($e->getMessage());
         }
         break;
+
+    // Executes a chunk of the statements of a dump in order and returns their
+    // number. The session statements of previous chunks are executed first,
+    // since every chunk has a connection of its own.
+    case 'restore':
+        try {
+            $pdo = connect(false);
+            if(!empty($input->Session)) {
+                foreach($input->Session as $stmt) {
+                    $pdo->exec($stmt);
+                }
+            }
+            foreach($input->Statements as $stmt) {
+                $pdo->exec($stmt);
+            }
+            echo json_encode(count($input->Statements));
+        } catch(Exception $e) {
+            http_response_code(500);
+            echo json_encode($e->getMessage());
+        }
+        break;
 }
\ No newline at end of file
@@ -1,40 +1,77 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 
 	"github.com/zippoxer/splace/web"
 
+	_ "github.com/denisenkom/go-mssqldb"
 	_ "github.com/go-sql-driver/mysql"
-)
-
-var (
-	dev = flag.Bool("dev", false, "")
+	_ "github.com/lib/pq"
 )
 
 func main() {
-	flag.Parse()
+	args := os.Args[1:]
+
+	// Without a command, splace serves the web UI as it always did.
+	run := runServe
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		run = nil
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				run = cmd.run
+			}
+		}
+		if run == nil {
+			usage()
+			os.Exit(exitError)
+		}
+		args = args[1:]
+	}
+
+	ctx, cancel := interruptContext()
+	code, err := run(ctx, args)
+	cancel()
+	if err != nil && err != errUsage {
+		fmt.Fprintln(os.Stderr, "splace:", err)
+	}
+	os.Exit(code)
+}
+
+func runServe(ctx context.Context, args []string) (int, error) {
+	var (
+		fs        = newFlagSet("serve")
+		dev       = fs.Bool("dev", false, "")
+		addr      = fs.String("addr", "127.0.0.1:30993", "address to listen on")
+		noBrowser = fs.Bool("no-browser", false, "don't open the web UI in a browser")
+	)
+	if err := fs.Parse(args); err != nil {
+		return exitError, errUsage
+	}
 
 	app := web.New(web.Options{
 		Path:  ".",
 		Debug: *dev,
-		Addr:  "127.0.0.1:30993",
+		Addr:  *addr,
 	})
 	go func() {
 		log.Fatal(app.Run())
 	}()
 
-	if !*dev {
-		if err := openBrowser("http://localhost:30993"); err != nil {
+	if !*dev && !*noBrowser {
+		if err := openBrowser("http://" + strings.Replace(*addr, "127.0.0.1", "localhost", 1)); err != nil {
 			log.Println(err)
 		}
 	}
 
-	select {}
+	<-ctx.Done()
+	return exitOK, nil
 }
 
 func openBrowser(url string) error {
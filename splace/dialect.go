@@ -0,0 +1,669 @@
+package splace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+// dialect writes the engine specific parts of a query.
+type dialect interface {
+	// quote quotes a column or table name.
+	quote(name string) string
+
+	// literal quotes and escapes a string literal.
+	literal(s string) string
+
+	// text returns the quoted column col as compared with a string literal
+	// in Equals mode.
+	text(col string) string
+
+	// match returns a condition matching the quoted column col against search.
+	match(col, search string, mode Mode) string
+
+	// replace returns an expression replacing search with replace in the quoted column col.
+	replace(col, search, replace string, mode Mode) string
+
+	// occurrences returns an expression counting the occurrences of search
+	// in the quoted column col, matched the same way as in Contains mode.
+	occurrences(col, search string) string
+
+	// selectQuery returns a query selecting columns, which is either * or a list of quoted
+	// columns. where and order are either empty or complete clauses with a trailing space.
+	selectQuery(columns, table, where, order string, offset, limit int) string
+	updateQuery(table, set, where string, limit int) string
+
+	// placeholder returns the placeholder of the n-th query argument, starting at 1.
+	placeholder(n int) string
+
+	// same returns a condition matching when the quoted column col holds
+	// exactly the value of the placeholder, byte for byte.
+	same(col, placeholder string) string
+
+	// keyQuery returns a query listing the name and columns of every key that
+	// identifies the rows of a table: the primary key first, then unique keys
+	// without nullable columns. Columns are listed in key order.
+	keyQuery(table string) string
+
+	// tablesQuery returns a query listing the table name followed by the
+	// columnInfo of every column in the database. Tables outside the
+	// default schema are qualified as schema.table.
+	tablesQuery() string
+
+	// schemasQuery returns a query listing the schemas of the server, or of the
+	// database on engines where schemas live inside a database, leaving out
+	// system schemas.
+	schemasQuery() string
+
+	// schemaTablesQuery returns a query listing the schema and table name
+	// followed by the columnInfo of every column in the given schemas.
+	schemaTablesQuery(schemas []string) string
+
+	// replacable reports whether a column of the given type can be
+	// assigned the result of replace.
+	replacable(columnType string) bool
+
+	// supports reports whether the engine can search, or update if update is true, in the given mode.
+	supports(mode Mode, update bool) bool
+}
+
+func dialectOf(engine querier.Engine) dialect {
+	switch engine {
+	case querier.PostgreSQL:
+		return postgresDialect{}
+	case querier.SQLite:
+		return sqliteDialect{}
+	case querier.SQLServer:
+		return sqlserverDialect{}
+	}
+	return mysqlDialect{}
+}
+
+// splitTable splits a schema qualified table name into its schema and table.
+// The schema is empty if the name isn't qualified.
+func splitTable(table string) (schema, name string) {
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return "", table
+}
+
+// tableSchema returns the schema of a schema qualified table name, or an empty string.
+func tableSchema(table string) string {
+	schema, _ := splitTable(table)
+	return schema
+}
+
+// quoteTable quotes a table name, quoting each part
+// of a schema qualified name separately.
+func quoteTable(d dialect, table string) string {
+	if schema, name := splitTable(table); schema != "" {
+		return d.quote(schema) + "." + d.quote(name)
+	}
+	return d.quote(table)
+}
+
+// literalList returns a comma separated list of string literals.
+func literalList(d dialect, values []string) string {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = d.literal(v)
+	}
+	return strings.Join(literals, ", ")
+}
+
+// likeEscape escapes the wildcards of a LIKE pattern with a backslash.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) quote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (mysqlDialect) literal(s string) string {
+	return "'" + queryEscape(s) + "'"
+}
+
+func (mysqlDialect) text(col string) string {
+	return col
+}
+
+func (d mysqlDialect) match(col, search string, mode Mode) string {
+	switch mode {
+	case Equals:
+		return col + " = " + d.literal(search)
+	case Contains:
+		return col + " LIKE BINARY " + d.literal("%"+likeEscape(search)+"%")
+	case Like:
+		return col + " LIKE BINARY " + d.literal(search)
+	case Regexp:
+		return col + " REGEXP " + d.literal(search)
+	}
+	panic(fmt.Sprintf("mysqlDialect.match: unknown mode %d", mode))
+}
+
+func (d mysqlDialect) replace(col, search, replace string, mode Mode) string {
+	switch mode {
+	case Equals:
+		return d.literal(replace)
+	case Contains:
+		return "REPLACE(" + col + ", " + d.literal(search) + ", " + d.literal(replace) + ")"
+	case Regexp:
+		return "REGEXP_REPLACE(" + col + ", " + d.literal(search) + ", " + d.literal(replace) + ")"
+	}
+	panic(fmt.Sprintf("mysqlDialect.replace: update queries don't support mode %d", mode))
+}
+
+func (d mysqlDialect) occurrences(col, search string) string {
+	return "(CHAR_LENGTH(" + col + ") - CHAR_LENGTH(REPLACE(" + col + ", " + d.literal(search) + ", ''))) DIV CHAR_LENGTH(" + d.literal(search) + ")"
+}
+
+func (d mysqlDialect) selectQuery(columns, table, where, order string, offset, limit int) string {
+	q := "SELECT " + columns + " FROM " + quoteTable(d, table) + " " + where + order
+	if limit > 0 {
+		if offset > 0 {
+			q += fmt.Sprintf("LIMIT %d, %d", offset, limit)
+		} else {
+			q += fmt.Sprintf("LIMIT %d", limit)
+		}
+	}
+	return q
+}
+
+func (d mysqlDialect) updateQuery(table, set, where string, limit int) string {
+	q := "UPDATE " + quoteTable(d, table) + " " + set + where
+	if limit > 0 {
+		q += fmt.Sprintf("LIMIT %d", limit)
+	}
+	return q
+}
+
+func (mysqlDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) same(col, placeholder string) string {
+	return col + " = BINARY " + placeholder
+}
+
+func (d mysqlDialect) keyQuery(table string) string {
+	schema, name := splitTable(table)
+	schemaExpr := "DATABASE()"
+	if schema != "" {
+		schemaExpr = d.literal(schema)
+	}
+	where := `TABLE_SCHEMA = ` + schemaExpr + ` AND TABLE_NAME = ` + d.literal(name)
+	return `SELECT INDEX_NAME, COLUMN_NAME FROM INFORMATION_SCHEMA.STATISTICS ` +
+		`WHERE ` + where + ` AND NON_UNIQUE = 0 AND INDEX_NAME NOT IN (` +
+		`SELECT INDEX_NAME FROM INFORMATION_SCHEMA.STATISTICS WHERE ` + where + ` AND NULLABLE = 'YES') ` +
+		`ORDER BY INDEX_NAME = 'PRIMARY' DESC, INDEX_NAME, SEQ_IN_INDEX`
+}
+
+func (mysqlDialect) tablesQuery() string {
+	return mysqlColumnsQuery("c.TABLE_NAME", "= DATABASE()")
+}
+
+func (mysqlDialect) schemasQuery() string {
+	return `SELECT SCHEMA_NAME FROM INFORMATION_SCHEMA.SCHEMATA ` +
+		`WHERE SCHEMA_NAME NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys') ` +
+		`ORDER BY SCHEMA_NAME`
+}
+
+func (d mysqlDialect) schemaTablesQuery(schemas []string) string {
+	return mysqlColumnsQuery("c.TABLE_SCHEMA, c.TABLE_NAME", "IN ("+literalList(d, schemas)+")")
+}
+
+// mysqlColumnsQuery lists the columns of the schemas matching the schema
+// condition, preceded by the table name expressions of names.
+func mysqlColumnsQuery(names, schema string) string {
+	// Generated columns are marked VIRTUAL or STORED in EXTRA, and PERSISTENT
+	// in older MariaDB, while DEFAULT_GENERATED marks expression defaults.
+	// GENERATION_EXPRESSION is left out, since MySQL before 5.7.6 and
+	// MariaDB before 10.2 lack it.
+	return `SELECT ` + names + `, c.COLUMN_NAME, c.COLUMN_TYPE, t.TABLE_TYPE, ` +
+		`c.EXTRA LIKE '%VIRTUAL%' OR c.EXTRA LIKE '%STORED%' OR c.EXTRA LIKE '%PERSISTENT%', ` +
+		`'', c.IS_NULLABLE, c.CHARACTER_MAXIMUM_LENGTH, ` +
+		`c.CHARACTER_SET_NAME, c.COLLATION_NAME, ` +
+		`CASE WHEN k.IS_PRIMARY IS NULL THEN '' WHEN k.IS_PRIMARY THEN 'PRI' ELSE 'UNI' END ` +
+		`FROM INFORMATION_SCHEMA.COLUMNS AS c ` +
+		`JOIN INFORMATION_SCHEMA.TABLES AS t ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME ` +
+		`LEFT JOIN (SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, MAX(INDEX_NAME = 'PRIMARY') AS IS_PRIMARY ` +
+		`FROM INFORMATION_SCHEMA.STATISTICS WHERE NON_UNIQUE = 0 AND TABLE_SCHEMA ` + schema + ` ` +
+		`GROUP BY TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME) AS k ` +
+		`ON k.TABLE_SCHEMA = c.TABLE_SCHEMA AND k.TABLE_NAME = c.TABLE_NAME AND k.COLUMN_NAME = c.COLUMN_NAME ` +
+		`WHERE c.TABLE_SCHEMA ` + schema + ` ` +
+		`ORDER BY c.TABLE_SCHEMA, c.TABLE_NAME, c.ORDINAL_POSITION`
+}
+
+func (mysqlDialect) replacable(columnType string) bool {
+	return true
+}
+
+func (mysqlDialect) supports(mode Mode, update bool) bool {
+	return !update || mode != Like
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (postgresDialect) literal(s string) string {
+	// Backslashes are literal as long as standard_conforming_strings is on,
+	// which is the default since PostgreSQL 9.1.
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func (postgresDialect) text(col string) string {
+	return col
+}
+
+func (d postgresDialect) match(col, search string, mode Mode) string {
+	// Cast to text so non-textual columns can be searched too.
+	col += "::text"
+	switch mode {
+	case Equals:
+		return col + " = " + d.literal(search)
+	case Contains:
+		return col + " LIKE " + d.literal("%"+likeEscape(search)+"%")
+	case Like:
+		return col + " LIKE " + d.literal(search)
+	case Regexp:
+		return col + " ~ " + d.literal(search)
+	}
+	panic(fmt.Sprintf("postgresDialect.match: unknown mode %d", mode))
+}
+
+func (d postgresDialect) replace(col, search, replace string, mode Mode) string {
+	switch mode {
+	case Equals:
+		return d.literal(replace)
+	case Contains:
+		return "replace(" + col + ", " + d.literal(search) + ", " + d.literal(replace) + ")"
+	case Regexp:
+		return "regexp_replace(" + col + ", " + d.literal(search) + ", " + d.literal(replace) + ", 'g')"
+	}
+	panic(fmt.Sprintf("postgresDialect.replace: update queries don't support mode %d", mode))
+}
+
+func (d postgresDialect) occurrences(col, search string) string {
+	col += "::text"
+	return "(length(" + col + ") - length(replace(" + col + ", " + d.literal(search) + ", ''))) / length(" + d.literal(search) + ")"
+}
+
+func (d postgresDialect) selectQuery(columns, table, where, order string, offset, limit int) string {
+	q := "SELECT " + columns + " FROM " + quoteTable(d, table) + " " + where + order
+	if limit > 0 {
+		q += fmt.Sprintf("LIMIT %d ", limit)
+	}
+	if offset > 0 {
+		q += fmt.Sprintf("OFFSET %d", offset)
+	}
+	return q
+}
+
+func (d postgresDialect) updateQuery(table, set, where string, limit int) string {
+	if limit <= 0 {
+		return "UPDATE " + quoteTable(d, table) + " " + set + where
+	}
+	// PostgreSQL has no UPDATE ... LIMIT, so limit a sub-select of row locations instead.
+	return fmt.Sprintf("UPDATE %s %sWHERE ctid IN (SELECT ctid FROM %s %sLIMIT %d)",
+		quoteTable(d, table), set, quoteTable(d, table), where, limit)
+}
+
+func (postgresDialect) placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) same(col, placeholder string) string {
+	return col + " = " + placeholder
+}
+
+func (d postgresDialect) keyQuery(table string) string {
+	// Expression and partial indexes don't identify rows by their columns.
+	return `SELECT i.indexrelid::regclass::text, a.attname FROM pg_index AS i ` +
+		`JOIN pg_attribute AS a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey) ` +
+		`WHERE i.indrelid = ` + d.literal(quoteTable(d, table)) + `::regclass AND i.indisunique ` +
+		`AND i.indexprs IS NULL AND i.indpred IS NULL AND NOT EXISTS (` +
+		`SELECT 1 FROM pg_attribute AS n WHERE n.attrelid = i.indrelid ` +
+		`AND n.attnum = ANY(i.indkey) AND NOT n.attnotnull) ` +
+		`ORDER BY i.indisprimary DESC, i.indexrelid, array_position(i.indkey::int2[], a.attnum)`
+}
+
+func (postgresDialect) tablesQuery() string {
+	return postgresColumnsQuery(`CASE WHEN c.table_schema = current_schema() THEN c.table_name `+
+		`ELSE c.table_schema || '.' || c.table_name END`,
+		`c.table_schema NOT IN ('pg_catalog', 'information_schema')`)
+}
+
+func (postgresDialect) schemasQuery() string {
+	return `SELECT schema_name FROM information_schema.schemata ` +
+		`WHERE schema_name != 'information_schema' AND schema_name NOT LIKE 'pg\_%' ` +
+		`ORDER BY schema_name`
+}
+
+func (d postgresDialect) schemaTablesQuery(schemas []string) string {
+	return postgresColumnsQuery("c.table_schema, c.table_name", "c.table_schema IN ("+literalList(d, schemas)+")")
+}
+
+// postgresColumnsQuery lists the columns matching where,
+// preceded by the table name expressions of names.
+func postgresColumnsQuery(names, where string) string {
+	return `SELECT ` + names + `, c.column_name, c.udt_name, t.table_type, ` +
+		`CASE WHEN c.is_generated = 'ALWAYS' THEN 1 ELSE 0 END, c.generation_expression, ` +
+		`c.is_nullable, c.character_maximum_length, c.character_set_name, c.collation_name, ` +
+		`COALESCE((SELECT CASE WHEN bool_or(tc.constraint_type = 'PRIMARY KEY') THEN 'PRI' ELSE 'UNI' END ` +
+		`FROM information_schema.key_column_usage AS k JOIN information_schema.table_constraints AS tc ` +
+		`ON tc.constraint_schema = k.constraint_schema AND tc.constraint_name = k.constraint_name ` +
+		`WHERE k.table_schema = c.table_schema AND k.table_name = c.table_name ` +
+		`AND k.column_name = c.column_name AND tc.constraint_type IN ('PRIMARY KEY', 'UNIQUE') ` +
+		`HAVING count(*) > 0), '') ` +
+		`FROM information_schema.columns AS c JOIN information_schema.tables AS t ` +
+		`ON t.table_schema = c.table_schema AND t.table_name = c.table_name ` +
+		`WHERE ` + where + ` ` +
+		`ORDER BY c.table_schema, c.table_name, c.ordinal_position`
+}
+
+func (postgresDialect) replacable(columnType string) bool {
+	// PostgreSQL doesn't implicitly cast text back to most types.
+	switch columnType {
+	case "text", "varchar", "bpchar", "citext", "name":
+		return true
+	}
+	return false
+}
+
+func (postgresDialect) supports(mode Mode, update bool) bool {
+	return !update || mode != Like
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (sqliteDialect) literal(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func (sqliteDialect) text(col string) string {
+	return col
+}
+
+func (d sqliteDialect) match(col, search string, mode Mode) string {
+	switch mode {
+	case Equals:
+		return col + " = " + d.literal(search)
+	case Contains:
+		// instr is case sensitive and needs no escaping, unlike LIKE.
+		return "instr(" + col + ", " + d.literal(search) + ") > 0"
+	case Like:
+		// The querier turns on case_sensitive_like.
+		return col + " LIKE " + d.literal(search)
+	case Regexp:
+		// REGEXP and regexp_replace are implemented by the querier with Go's regexp.
+		return col + " REGEXP " + d.literal(search)
+	}
+	panic(fmt.Sprintf("sqliteDialect.match: unknown mode %d", mode))
+}
+
+func (d sqliteDialect) replace(col, search, replace string, mode Mode) string {
+	var expr string
+	switch mode {
+	case Equals:
+		return d.literal(replace)
+	case Contains:
+		expr = "replace(" + col + ", " + d.literal(search) + ", " + d.literal(replace) + ")"
+	case Regexp:
+		expr = "regexp_replace(" + col + ", " + d.literal(search) + ", " + d.literal(replace) + ")"
+	default:
+		panic(fmt.Sprintf("sqliteDialect.replace: update queries don't support mode %d", mode))
+	}
+	// SQLite's string functions return text for any value, including NULL and blobs,
+	// so only touch values that match.
+	return "CASE WHEN " + d.match(col, search, mode) + " THEN " + expr + " ELSE " + col + " END"
+}
+
+func (d sqliteDialect) occurrences(col, search string) string {
+	// Lengths of blobs are in bytes, and those of text in characters.
+	col = "CAST(" + col + " AS TEXT)"
+	return "(length(" + col + ") - length(replace(" + col + ", " + d.literal(search) + ", ''))) / length(" + d.literal(search) + ")"
+}
+
+func (d sqliteDialect) selectQuery(columns, table, where, order string, offset, limit int) string {
+	q := "SELECT " + columns + " FROM " + quoteTable(d, table) + " " + where + order
+	if limit > 0 {
+		q += fmt.Sprintf("LIMIT %d ", limit)
+	} else if offset > 0 {
+		q += "LIMIT -1 "
+	}
+	if offset > 0 {
+		q += fmt.Sprintf("OFFSET %d", offset)
+	}
+	return q
+}
+
+func (d sqliteDialect) updateQuery(table, set, where string, limit int) string {
+	if limit <= 0 {
+		return "UPDATE " + quoteTable(d, table) + " " + set + where
+	}
+	// UPDATE ... LIMIT is a compile time option of SQLite, so limit a sub-select of rowids instead.
+	return fmt.Sprintf("UPDATE %s %sWHERE rowid IN (SELECT rowid FROM %s %sLIMIT %d)",
+		quoteTable(d, table), set, quoteTable(d, table), where, limit)
+}
+
+func (sqliteDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) same(col, placeholder string) string {
+	return col + " = " + placeholder
+}
+
+func (d sqliteDialect) keyQuery(table string) string {
+	// The table-valued pragmas take the schema as their last argument.
+	schema, name := splitTable(table)
+	if schema == "" {
+		schema = "main"
+	}
+	s := d.literal(schema)
+	t := d.literal(name) + ", " + s
+	// Expression indexes list their columns without a name.
+	return `SELECT k, name FROM (` +
+		`SELECT 0 AS o, '' AS k, name, pk AS seq FROM pragma_table_info(` + t + `) WHERE pk > 0 ` +
+		`UNION ALL SELECT 1, l.name, c.name, c.seqno FROM pragma_index_list(` + t + `) AS l, ` +
+		`pragma_index_info(l.name, ` + s + `) AS c WHERE l."unique" AND NOT l.partial AND l.origin != 'pk' ` +
+		`AND NOT EXISTS (SELECT 1 FROM pragma_index_info(l.name, ` + s + `) AS n ` +
+		`LEFT JOIN pragma_table_info(` + t + `) AS p ON p.name = n.name ` +
+		`WHERE n.name IS NULL OR NOT p."notnull")` +
+		`) ORDER BY o, k, seq`
+}
+
+func (d sqliteDialect) tablesQuery() string {
+	return d.columnsQuery("main", "")
+}
+
+func (sqliteDialect) schemasQuery() string {
+	return `SELECT name FROM pragma_database_list WHERE name != 'temp' ORDER BY seq`
+}
+
+func (d sqliteDialect) schemaTablesQuery(schemas []string) string {
+	// The schemas of SQLite are attached databases, each with a master table of its own.
+	selects := make([]string, len(schemas))
+	for i, schema := range schemas {
+		selects[i] = `SELECT * FROM (` + d.columnsQuery(schema, d.literal(schema)+", ") + `)`
+	}
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// columnsQuery lists the columns of the tables and views of a schema,
+// preceded by the table name expressions of names.
+func (d sqliteDialect) columnsQuery(schema, names string) string {
+	// Hidden columns 2 and 3 are virtual and stored generated columns.
+	s := d.literal(schema)
+	return `SELECT ` + names + `m.name, p.name, p.type, ` +
+		`CASE m.type WHEN 'view' THEN 'VIEW' ELSE 'BASE TABLE' END, p.hidden IN (2, 3), NULL, ` +
+		`CASE WHEN p."notnull" THEN 'NO' ELSE 'YES' END, NULL, NULL, NULL, ` +
+		`CASE WHEN p.pk > 0 THEN 'PRI' WHEN EXISTS (SELECT 1 FROM pragma_index_list(m.name, ` + s + `) AS l, ` +
+		`pragma_index_info(l.name, ` + s + `) AS i WHERE l."unique" AND i.name = p.name) THEN 'UNI' ELSE '' END ` +
+		`FROM ` + d.quote(schema) + `.sqlite_master AS m, pragma_table_xinfo(m.name, ` + s + `) AS p ` +
+		`WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite\_%' ESCAPE '\' ` +
+		`ORDER BY m.name, p.cid`
+}
+
+func (sqliteDialect) replacable(columnType string) bool {
+	return true
+}
+
+func (sqliteDialect) supports(mode Mode, update bool) bool {
+	return !update || mode != Like
+}
+
+type sqlserverDialect struct{}
+
+func (sqlserverDialect) quote(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+func (sqlserverDialect) literal(s string) string {
+	return "N'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// text casts the quoted column col to nvarchar(max), which every textual
+// type converts to and which REPLACE() doesn't truncate at 8,000 bytes.
+func (sqlserverDialect) text(col string) string {
+	return "CAST(" + col + " AS nvarchar(max))"
+}
+
+func (d sqlserverDialect) match(col, search string, mode Mode) string {
+	switch mode {
+	case Equals:
+		return d.text(col) + " = " + d.literal(search)
+	case Contains:
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`).Replace(search)
+		return d.text(col) + " COLLATE Latin1_General_BIN LIKE " + d.literal("%"+escaped+"%") + ` ESCAPE '\'`
+	case Like:
+		return d.text(col) + " COLLATE Latin1_General_BIN LIKE " + d.literal(search)
+	}
+	panic(fmt.Sprintf("sqlserverDialect.match: unsupported mode %d", mode))
+}
+
+func (d sqlserverDialect) replace(col, search, replace string, mode Mode) string {
+	switch mode {
+	case Equals:
+		return d.literal(replace)
+	case Contains:
+		return "REPLACE(" + d.text(col) + " COLLATE Latin1_General_BIN, " + d.literal(search) + ", " + d.literal(replace) + ")"
+	}
+	panic(fmt.Sprintf("sqlserverDialect.replace: update queries don't support mode %d", mode))
+}
+
+func (d sqlserverDialect) occurrences(col, search string) string {
+	// LEN() ignores trailing spaces, unlike DATALENGTH().
+	return "(DATALENGTH(" + d.text(col) + ") - DATALENGTH(REPLACE(" + d.text(col) + " COLLATE Latin1_General_BIN, " +
+		d.literal(search) + ", N''))) / DATALENGTH(" + d.literal(search) + ")"
+}
+
+func (d sqlserverDialect) selectQuery(columns, table, where, order string, offset, limit int) string {
+	if offset <= 0 {
+		if limit > 0 {
+			return fmt.Sprintf("SELECT TOP (%d) %s FROM %s %s%s", limit, columns, quoteTable(d, table), where, order)
+		}
+		return "SELECT " + columns + " FROM " + quoteTable(d, table) + " " + where + order
+	}
+	if order == "" {
+		// OFFSET requires an ORDER BY, any order would do.
+		order = "ORDER BY (SELECT NULL) "
+	}
+	q := fmt.Sprintf("SELECT %s FROM %s %s%sOFFSET %d ROWS", columns, quoteTable(d, table), where, order, offset)
+	if limit > 0 {
+		q += fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", limit)
+	}
+	return q
+}
+
+func (d sqlserverDialect) updateQuery(table, set, where string, limit int) string {
+	if limit > 0 {
+		return fmt.Sprintf("UPDATE TOP (%d) %s %s%s", limit, quoteTable(d, table), set, where)
+	}
+	return "UPDATE " + quoteTable(d, table) + " " + set + where
+}
+
+func (sqlserverDialect) placeholder(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+func (d sqlserverDialect) same(col, placeholder string) string {
+	return d.text(col) + " COLLATE Latin1_General_BIN = " + placeholder
+}
+
+func (d sqlserverDialect) keyQuery(table string) string {
+	return `SELECT i.name, c.name FROM sys.indexes AS i ` +
+		`JOIN sys.index_columns AS ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id ` +
+		`JOIN sys.columns AS c ON c.object_id = ic.object_id AND c.column_id = ic.column_id ` +
+		`WHERE i.object_id = OBJECT_ID(` + d.literal(quoteTable(d, table)) + `) ` +
+		`AND i.is_unique = 1 AND i.has_filter = 0 AND ic.is_included_column = 0 AND NOT EXISTS (` +
+		`SELECT 1 FROM sys.index_columns AS n ` +
+		`JOIN sys.columns AS nc ON nc.object_id = n.object_id AND nc.column_id = n.column_id ` +
+		`WHERE n.object_id = i.object_id AND n.index_id = i.index_id AND nc.is_nullable = 1) ` +
+		`ORDER BY i.is_primary_key DESC, i.index_id, ic.key_ordinal`
+}
+
+func (sqlserverDialect) tablesQuery() string {
+	return sqlserverColumnsQuery(`CASE WHEN c.TABLE_SCHEMA = SCHEMA_NAME() THEN c.TABLE_NAME `+
+		`ELSE c.TABLE_SCHEMA + '.' + c.TABLE_NAME END`, "1 = 1")
+}
+
+func (sqlserverDialect) schemasQuery() string {
+	// Every database has schemas for the built-in roles, which hold no tables.
+	return `SELECT DISTINCT TABLE_SCHEMA FROM INFORMATION_SCHEMA.TABLES ` +
+		`WHERE TABLE_SCHEMA NOT IN ('sys', 'INFORMATION_SCHEMA') ORDER BY TABLE_SCHEMA`
+}
+
+func (d sqlserverDialect) schemaTablesQuery(schemas []string) string {
+	return sqlserverColumnsQuery("c.TABLE_SCHEMA, c.TABLE_NAME", "c.TABLE_SCHEMA IN ("+literalList(d, schemas)+")")
+}
+
+// sqlserverColumnsQuery lists the columns matching where,
+// preceded by the table name expressions of names.
+func sqlserverColumnsQuery(names, where string) string {
+	object := `OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME))`
+	return `SELECT ` + names + `, c.COLUMN_NAME, c.DATA_TYPE, t.TABLE_TYPE, ` +
+		`COLUMNPROPERTY(` + object + `, c.COLUMN_NAME, 'IsComputed'), ` +
+		`(SELECT cc.definition FROM sys.computed_columns AS cc ` +
+		`WHERE cc.object_id = ` + object + ` AND cc.name = c.COLUMN_NAME), ` +
+		`c.IS_NULLABLE, c.CHARACTER_MAXIMUM_LENGTH, c.CHARACTER_SET_NAME, c.COLLATION_NAME, ` +
+		`COALESCE((SELECT CASE WHEN MAX(CASE WHEN tc.CONSTRAINT_TYPE = 'PRIMARY KEY' THEN 1 ELSE 0 END) = 1 ` +
+		`THEN 'PRI' ELSE 'UNI' END ` +
+		`FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE AS k JOIN INFORMATION_SCHEMA.TABLE_CONSTRAINTS AS tc ` +
+		`ON tc.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND tc.CONSTRAINT_NAME = k.CONSTRAINT_NAME ` +
+		`WHERE k.TABLE_SCHEMA = c.TABLE_SCHEMA AND k.TABLE_NAME = c.TABLE_NAME ` +
+		`AND k.COLUMN_NAME = c.COLUMN_NAME AND tc.CONSTRAINT_TYPE IN ('PRIMARY KEY', 'UNIQUE') ` +
+		`HAVING COUNT(*) > 0), '') ` +
+		`FROM INFORMATION_SCHEMA.COLUMNS AS c JOIN INFORMATION_SCHEMA.TABLES AS t ` +
+		`ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME ` +
+		`WHERE ` + where + ` ` +
+		`ORDER BY c.TABLE_SCHEMA, c.TABLE_NAME, c.ORDINAL_POSITION`
+}
+
+func (sqlserverDialect) replacable(columnType string) bool {
+	// nvarchar doesn't implicitly convert to binary types,
+	// and rowversion columns can't be updated at all.
+	switch strings.ToLower(columnType) {
+	case "binary", "varbinary", "image", "timestamp", "rowversion",
+		"hierarchyid", "geometry", "geography", "sql_variant":
+		return false
+	}
+	return true
+}
+
+func (sqlserverDialect) supports(mode Mode, update bool) bool {
+	// SQL Server has no regular expressions.
+	return mode != Regexp && (!update || mode != Like)
+}
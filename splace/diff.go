@@ -0,0 +1,148 @@
+package splace
+
+// maxDiffEdits bounds the work of diffing a value. Values that differ in
+// more characters are described by a single edit.
+const maxDiffEdits = 1000
+
+// minDiffGap is the number of unchanged characters it takes to separate two edits.
+const minDiffGap = 2
+
+// Edit is a difference between a value and its replacement: Old, starting at
+// Offset characters into the value, is replaced by New.
+type Edit struct {
+	Offset int
+	Old    string
+	New    string
+}
+
+// diff returns the character-level edits that turn old into new.
+func diff(old, new string) []Edit {
+	a, b := []rune(old), []rune(new)
+
+	// Replacements are usually small compared to the value,
+	// so the common prefix and suffix are skipped up front.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+
+	edits, ok := myers(a, b)
+	if !ok {
+		return []Edit{{Offset: prefix, Old: string(a), New: string(b)}}
+	}
+	for i := range edits {
+		edits[i].Offset += prefix
+	}
+	return edits
+}
+
+// myers finds the shortest edit script between a and b with Myers' algorithm.
+// ok is false if it takes more than maxDiffEdits insertions and deletions.
+func myers(a, b []rune) (edits []Edit, ok bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+	// v[off+k] is the furthest x reached on diagonal k = x - y.
+	off := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return myersEdits(a, b, trace, off), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// myersEdits walks back through the trace of myers and
+// merges adjacent insertions and deletions into edits.
+func myersEdits(a, b []rune, trace [][]int, off int) []Edit {
+	x, y := len(a), len(b)
+	// Edits are found from the end, with end offsets into a and b.
+	type span struct{ aStart, aEnd, bStart, bEnd int }
+	var spans []span
+	add := func(ax, by int, del bool) {
+		last := len(spans) - 1
+		if last >= 0 && spans[last].aStart == ax+boolInt(del) && spans[last].bStart == by+boolInt(!del) {
+			spans[last].aStart, spans[last].bStart = ax, by
+			return
+		}
+		spans = append(spans, span{ax, ax + boolInt(del), by, by + boolInt(!del)})
+	}
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			add(x, prevY, false)
+		} else {
+			add(prevX, y, true)
+		}
+		x, y = prevX, prevY
+	}
+
+	// Edits a few characters apart are merged, since "com" to "org"
+	// reads better than "c" to "" and "m" to "rg".
+	var merged []span
+	for i := len(spans) - 1; i >= 0; i-- {
+		s := spans[i]
+		if last := len(merged) - 1; last >= 0 && s.aStart-merged[last].aEnd < minDiffGap {
+			merged[last].aEnd, merged[last].bEnd = s.aEnd, s.bEnd
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	edits := make([]Edit, len(merged))
+	for i, s := range merged {
+		edits[i] = Edit{
+			Offset: s.aStart,
+			Old:    string(a[s.aStart:s.aEnd]),
+			New:    string(b[s.bStart:s.bEnd]),
+		}
+	}
+	return edits
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
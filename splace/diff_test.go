@@ -0,0 +1,36 @@
+package splace
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var diffTests = []struct {
+	old, new string
+	edits    []Edit
+}{
+	{"same", "same", nil},
+	{"http://old.com/a", "http://new.com/a", []Edit{{7, "old", "new"}}},
+	{"example.com", "example.org", []Edit{{8, "com", "org"}}},
+	{"example.com", "www.example.com", []Edit{{0, "", "www."}}},
+	{"a old b old c", "a new b new c", []Edit{{2, "old", "new"}, {8, "old", "new"}}},
+	{"héllo wörld", "hello world", []Edit{{1, "é", "e"}, {7, "ö", "o"}}},
+	{`s:7:"old.com";`, `s:11:"www.new.com";`, []Edit{{2, "7", "11"}, {5, "old", "www.new"}}},
+}
+
+func TestDiff(t *testing.T) {
+	for i, test := range diffTests {
+		edits := diff(test.old, test.new)
+		if !reflect.DeepEqual(edits, test.edits) {
+			t.Errorf("failed test %d: expected %v, got %v", i, test.edits, edits)
+		}
+	}
+
+	// Values too different to diff are a single edit.
+	old, new := strings.Repeat("a", 1000), strings.Repeat("b", 1000)
+	edits := diff(old, new)
+	if len(edits) != 1 || edits[0].Offset != 0 || edits[0].Old != old || edits[0].New != new {
+		t.Errorf("expected a single edit, got %d edits", len(edits))
+	}
+}
@@ -0,0 +1,888 @@
+package splace
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+// Source is something that can be searched like a database,
+// such as a database through Splace, or a dump file.
+type Source interface {
+	Tables(ctx context.Context) (TableMap, error)
+	Search(ctx context.Context, opt SearchOptions) *Searcher
+}
+
+var (
+	_ Source = (*Splace)(nil)
+	_ Source = (*DumpFile)(nil)
+)
+
+// DumpFile is a MySQL dump, plain or gzipped, searched and replaced in as a stream
+// without loading it into a server. Tables and columns are read from the CREATE TABLE
+// statements of the dump and rows from its INSERT statements, so the other statements
+// are left untouched.
+type DumpFile struct {
+	name string
+}
+
+func OpenDumpFile(name string) (*DumpFile, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, err
+	}
+	return &DumpFile{name: name}, nil
+}
+
+func (f *DumpFile) open() (io.ReadCloser, error) {
+	file, err := os.Open(f.name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := querier.Decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, file}, nil
+}
+
+// Tables lists the tables and columns created by the dump.
+func (f *DumpFile) Tables(ctx context.Context) (TableMap, error) {
+	r, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	tables := TableMap{}
+	s := querier.NewRawStatementScanner(r, querier.MySQL)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stmt, err := s.NextBytes()
+		if err == io.EOF {
+			return tables, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if table, columns, ok := parseCreateTable(stmt); ok {
+			tables[table] = columns
+		}
+	}
+}
+
+// Search finds the rows of the dump matching the search, in the order of the dump.
+// Objects is ignored, since only the INSERT statements of the dump are searched.
+// With Count, a result is sent for each table once its rows are counted.
+// Snippets is ignored, since rows of the dump can't be fetched again by key.
+func (f *DumpFile) Search(ctx context.Context, opt SearchOptions) *Searcher {
+	sr := newSearcher(ctx, nil, opt)
+	go func() {
+		defer close(sr.results)
+		defer close(sr.done)
+		sr.done <- f.search(sr)
+	}()
+	return sr
+}
+
+func (f *DumpFile) search(sr *Searcher) error {
+	match, err := sr.opt.valueMatcher()
+	if err != nil {
+		return err
+	}
+	r, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if sr.opt.Count {
+		return f.count(sr, r, match)
+	}
+
+	var rows chan []string
+	defer func() {
+		if rows != nil {
+			close(rows)
+		}
+	}()
+	lastTable := ""
+	return f.walk(sr.ctx, r, sr.opt.Tables, nil, func(stmt *dumpInsert, columns []string, selected []int) error {
+		if rows == nil || stmt.table != lastTable {
+			if rows != nil {
+				close(rows)
+			}
+			rows = make(chan []string, 128)
+			lastTable = stmt.table
+			sr.results <- SearchResult{
+				Table:   stmt.table,
+				Schema:  tableSchema(stmt.table),
+				Columns: columns,
+				Rows:    rows,
+				Start:   time.Now(),
+			}
+		}
+		for _, tuple := range stmt.tuples {
+			matched := false
+			for _, i := range selected {
+				if tuple[i].kind != dumpNull && match(tuple[i].text) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+			row := make([]string, len(tuple))
+			for i, v := range tuple {
+				row[i] = v.text
+			}
+			select {
+			case rows <- row:
+			case <-sr.ctx.Done():
+				return sr.ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// count counts the matches of each table, sending a result when the INSERT
+// statements of another table begin and at the end of the dump.
+func (f *DumpFile) count(sr *Searcher, r io.Reader, match func(string) bool) error {
+	if err := sr.opt.checkOccurrences(); err != nil {
+		return err
+	}
+	var occurrences func(string) int64
+	if sr.opt.Occurrences {
+		if sr.opt.Mode == Equals {
+			occurrences = func(string) int64 { return 1 }
+		} else {
+			occurrences = func(s string) int64 { return int64(strings.Count(s, sr.opt.Search)) }
+		}
+	}
+
+	var result *SearchResult
+	send := func() {
+		if result == nil {
+			return
+		}
+		rows := make(chan []string)
+		close(rows)
+		result.Rows = rows
+		sr.results <- *result
+		result = nil
+	}
+	err := f.walk(sr.ctx, r, sr.opt.Tables, nil, func(stmt *dumpInsert, columns []string, selected []int) error {
+		if result == nil || stmt.table != result.Table {
+			send()
+			result = &SearchResult{
+				Table:  stmt.table,
+				Schema: tableSchema(stmt.table),
+				Count:  &SearchCount{},
+				Start:  time.Now(),
+			}
+		}
+		// INSERT statements may list their columns in any order.
+		counts := make([]int, len(selected))
+		for j, i := range selected {
+			if index := columnIndexes(result.Columns, columns[i:i+1]); index != nil {
+				counts[j] = index[0]
+			} else {
+				counts[j] = len(result.Columns)
+				result.Columns = append(result.Columns, columns[i])
+				result.Count.Columns = append(result.Count.Columns, ColumnCount{Column: columns[i]})
+			}
+		}
+		for _, tuple := range stmt.tuples {
+			matched := false
+			for j, i := range selected {
+				if tuple[i].kind == dumpNull || !match(tuple[i].text) {
+					continue
+				}
+				matched = true
+				c := &result.Count.Columns[counts[j]]
+				c.Rows++
+				if occurrences != nil {
+					c.Occurrences += occurrences(tuple[i].text)
+				}
+			}
+			if matched {
+				result.Count.Rows++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	send()
+	return nil
+}
+
+// Replace makes the replacement in the INSERT statements of the dump
+// and writes the rewritten dump to w, uncompressed. The dump file itself
+// is left as it is, so Limit, Journal and Backup are ignored, and so is Objects.
+func (f *DumpFile) Replace(ctx context.Context, opt ReplaceOptions, w io.Writer) *Replacer {
+	r := newReplacer(ctx, nil, opt)
+	go func() {
+		defer close(r.results)
+		defer close(r.done)
+		r.done <- f.replace(r, w)
+	}()
+	return r
+}
+
+func (f *DumpFile) replace(r *Replacer, w io.Writer) error {
+	replace, err := r.opt.valueReplacer()
+	if err != nil {
+		return err
+	}
+	in, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Only the columns a replacement could be assigned to are replaced in.
+	tables := TableMap{}
+	for table, columns := range r.opt.Tables {
+		cols, _ := replacableColumns(dialectOf(querier.MySQL), columns)
+		for _, col := range cols {
+			tables[table] = append(tables[table], ColumnInfo{Column: col})
+		}
+	}
+
+	var affected chan int
+	defer func() {
+		if affected != nil {
+			close(affected)
+		}
+	}()
+	// Hits are counted once the replaced dump is written.
+	var hits []int64
+	if r.opt.Mapping != nil {
+		hits = make([]int64, len(r.opt.Mapping.Pairs()))
+	}
+	lastTable := ""
+	bw := bufio.NewWriter(w)
+	err = f.walk(r.ctx, in, tables, bw, func(stmt *dumpInsert, columns []string, selected []int) error {
+		if affected == nil || stmt.table != lastTable {
+			if affected != nil {
+				close(affected)
+			}
+			affected = make(chan int, 128)
+			lastTable = stmt.table
+			r.results <- ReplaceResult{
+				Table:        stmt.table,
+				Schema:       tableSchema(stmt.table),
+				AffectedRows: affected,
+				Start:        time.Now(),
+			}
+		}
+		n := 0
+		for _, tuple := range stmt.tuples {
+			changed := false
+			for _, i := range selected {
+				v := &tuple[i]
+				if v.kind == dumpNull || v.kind == dumpOther {
+					continue
+				}
+				if replaced := replace(v.text); replaced != v.text {
+					if hits != nil {
+						r.opt.countHitsInto(v.text, hits)
+					}
+					v.text = replaced
+					v.changed = true
+					changed = true
+				}
+			}
+			if changed {
+				n++
+			}
+		}
+		if n > 0 {
+			affected <- n
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if hits != nil {
+		r.opt.Mapping.addHits(hits)
+	}
+	return nil
+}
+
+// walk calls fn with every INSERT statement into the selected tables, along with
+// the columns of the table and the positions of the selected columns. With w,
+// every statement is written to it after fn returns, including changes fn made
+// to the values of an INSERT statement.
+func (f *DumpFile) walk(ctx context.Context, r io.Reader, tables TableMap, w io.Writer, fn func(stmt *dumpInsert, columns []string, selected []int) error) error {
+	created := map[string][]string{}
+	s := querier.NewRawStatementScanner(r, querier.MySQL)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		raw, err := s.NextBytes()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if table, columns, ok := parseCreateTable(raw); ok {
+			names := make([]string, len(columns))
+			for i, col := range columns {
+				names[i] = col.Column
+			}
+			created[table] = names
+		} else if stmt, ok, err := parseInsert(raw); err != nil {
+			return err
+		} else if ok && len(tables[stmt.table]) > 0 {
+			columns := stmt.columns
+			if columns == nil {
+				columns = created[stmt.table]
+				if columns == nil {
+					return fmt.Errorf("INSERT into %s before its CREATE TABLE", stmt.table)
+				}
+			}
+			for i, tuple := range stmt.tuples {
+				if len(tuple) != len(columns) {
+					return fmt.Errorf("row %d of an INSERT into %s has %d values for %d columns",
+						i+1, stmt.table, len(tuple), len(columns))
+				}
+			}
+			var selected []int
+			for _, col := range tables[stmt.table] {
+				for i, name := range columns {
+					if name == col.Column {
+						selected = append(selected, i)
+					}
+				}
+			}
+			if len(selected) > 0 {
+				if err := fn(stmt, columns, selected); err != nil {
+					return err
+				}
+				raw = stmt.rewrite()
+			}
+		}
+
+		if w != nil {
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// skipBlank returns the position of the first character of a raw statement
+// following i that isn't whitespace or part of a comment.
+func skipBlank(raw []byte, i int) int {
+	for i < len(raw) {
+		switch {
+		case raw[i] <= ' ':
+			i++
+		case raw[i] == '#' || raw[i] == '-' && i+1 < len(raw) && raw[i+1] == '-' &&
+			(i+2 == len(raw) || raw[i+2] <= ' '):
+			end := bytes.IndexByte(raw[i:], '\n')
+			if end < 0 {
+				return len(raw)
+			}
+			i += end + 1
+		case raw[i] == '/' && i+1 < len(raw) && raw[i+1] == '*' && (i+2 == len(raw) || raw[i+2] != '!'):
+			end := bytes.Index(raw[i+2:], []byte("*/"))
+			if end < 0 {
+				return len(raw)
+			}
+			i += end + 4
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// dumpTokens reads the words, names and punctuation at the start of a statement.
+type dumpTokens struct {
+	raw []byte
+	pos int
+}
+
+// word returns the next keyword or name, unquoting a quoted name, and whether it was
+// quoted. Names qualified with a schema, such as `db`.`table`, are joined with a dot.
+func (t *dumpTokens) word() (string, bool) {
+	t.pos = skipBlank(t.raw, t.pos)
+	if t.pos >= len(t.raw) {
+		return "", false
+	}
+	var parts []string
+	quoted := false
+	for {
+		if t.pos < len(t.raw) && t.raw[t.pos] == '`' {
+			quoted = true
+			var name strings.Builder
+			i := t.pos + 1
+			for i < len(t.raw) {
+				if t.raw[i] == '`' {
+					if i+1 < len(t.raw) && t.raw[i+1] == '`' {
+						name.WriteByte('`')
+						i += 2
+						continue
+					}
+					break
+				}
+				name.WriteByte(t.raw[i])
+				i++
+			}
+			parts = append(parts, name.String())
+			t.pos = i + 1
+		} else {
+			start := t.pos
+			for t.pos < len(t.raw) && isWordChar(t.raw[t.pos]) {
+				t.pos++
+			}
+			if t.pos == start {
+				break
+			}
+			parts = append(parts, string(t.raw[start:t.pos]))
+		}
+		if t.pos >= len(t.raw) || t.raw[t.pos] != '.' {
+			break
+		}
+		t.pos++
+	}
+	return strings.Join(parts, "."), quoted
+}
+
+// keyword reads the next word if it's the given keyword.
+func (t *dumpTokens) keyword(kw string) bool {
+	pos := t.pos
+	word, quoted := t.word()
+	if !quoted && strings.EqualFold(word, kw) {
+		return true
+	}
+	t.pos = pos
+	return false
+}
+
+// punct reads the next character if it's c.
+func (t *dumpTokens) punct(c byte) bool {
+	t.pos = skipBlank(t.raw, t.pos)
+	if t.pos < len(t.raw) && t.raw[t.pos] == c {
+		t.pos++
+		return true
+	}
+	return false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// parseCreateTable reads the table and columns of a CREATE TABLE statement.
+func parseCreateTable(raw []byte) (string, []ColumnInfo, bool) {
+	t := &dumpTokens{raw: raw}
+	if !t.keyword("CREATE") {
+		return "", nil, false
+	}
+	t.keyword("TEMPORARY")
+	if !t.keyword("TABLE") {
+		return "", nil, false
+	}
+	if t.keyword("IF") {
+		t.keyword("NOT")
+		t.keyword("EXISTS")
+	}
+	table, _ := t.word()
+	if table == "" || !t.punct('(') {
+		return "", nil, false
+	}
+
+	var columns []ColumnInfo
+	for {
+		// Each definition is a column, or a key or constraint.
+		start := t.pos
+		name, quoted := t.word()
+		if name == "" {
+			return "", nil, false
+		}
+		if quoted || !isKeyDefinition(name) {
+			t.pos = skipBlank(raw, t.pos)
+			typeStart := t.pos
+			for t.pos < len(raw) && isWordChar(raw[t.pos]) {
+				t.pos++
+			}
+			columnType := strings.ToLower(string(raw[typeStart:t.pos]))
+			if t.pos < len(raw) && raw[t.pos] == '(' {
+				end := skipParens(raw, t.pos)
+				columnType += string(raw[t.pos:end])
+				t.pos = end
+			}
+			for _, attr := range []string{"unsigned", "zerofill"} {
+				if t.keyword(attr) {
+					columnType += " " + attr
+				}
+			}
+			columns = append(columns, ColumnInfo{Column: name, Type: columnType})
+		}
+		// Skip to the end of the definition.
+		t.pos = start
+		end := skipDefinition(raw, t.pos)
+		if end >= len(raw) {
+			return "", nil, false
+		}
+		t.pos = end + 1
+		if raw[end] == ')' {
+			return table, columns, true
+		}
+	}
+}
+
+func isKeyDefinition(word string) bool {
+	switch strings.ToUpper(word) {
+	case "PRIMARY", "KEY", "INDEX", "UNIQUE", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK":
+		return true
+	}
+	return false
+}
+
+// skipParens returns the position following the parenthesis closing the one at i.
+func skipParens(raw []byte, i int) int {
+	depth := 0
+	for i < len(raw) {
+		switch raw[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case '\'', '"', '`':
+			i = skipQuoted(raw, i)
+			continue
+		}
+		i++
+	}
+	return i
+}
+
+// skipDefinition returns the position of the comma or closing parenthesis
+// ending the definition of a column or key starting at i.
+func skipDefinition(raw []byte, i int) int {
+	for i < len(raw) {
+		switch raw[i] {
+		case '(':
+			i = skipParens(raw, i)
+			continue
+		case ',', ')':
+			return i
+		case '\'', '"', '`':
+			i = skipQuoted(raw, i)
+			continue
+		}
+		i++
+	}
+	return i
+}
+
+// skipQuoted returns the position following the quoted string or name at i.
+func skipQuoted(raw []byte, i int) int {
+	quote := raw[i]
+	for i++; i < len(raw); i++ {
+		switch {
+		case raw[i] == '\\' && quote != '`':
+			i++
+		case raw[i] == quote:
+			if i+1 < len(raw) && raw[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+type dumpValueKind int
+
+const (
+	dumpString dumpValueKind = iota
+	dumpHex
+	dumpNull
+	dumpOther
+)
+
+// dumpValue is a value of an INSERT statement. The raw value is raw[start:end],
+// of which the characters before the quoted string, such as _binary, are prefix.
+type dumpValue struct {
+	start, end int
+	prefix     string
+	kind       dumpValueKind
+	text       string
+	changed    bool
+}
+
+type dumpInsert struct {
+	raw     []byte
+	table   string
+	columns []string
+	tuples  [][]dumpValue
+}
+
+// parseInsert reads an INSERT or REPLACE statement with VALUES. It returns false for
+// other statements, and an error for such a statement it can't read the values of.
+func parseInsert(raw []byte) (*dumpInsert, bool, error) {
+	t := &dumpTokens{raw: raw}
+	if !t.keyword("INSERT") && !t.keyword("REPLACE") {
+		return nil, false, nil
+	}
+	for t.keyword("LOW_PRIORITY") || t.keyword("DELAYED") || t.keyword("HIGH_PRIORITY") || t.keyword("IGNORE") {
+	}
+	t.keyword("INTO")
+	table, _ := t.word()
+	if table == "" {
+		return nil, false, nil
+	}
+	stmt := &dumpInsert{raw: raw, table: table}
+	if t.punct('(') {
+		for {
+			name, _ := t.word()
+			if name == "" {
+				return nil, false, nil
+			}
+			stmt.columns = append(stmt.columns, name)
+			if t.punct(')') {
+				break
+			}
+			if !t.punct(',') {
+				return nil, false, nil
+			}
+		}
+	}
+	if !t.keyword("VALUES") && !t.keyword("VALUE") {
+		// INSERT ... SELECT and INSERT ... SET hold no rows of their own.
+		return nil, false, nil
+	}
+
+	for {
+		if !t.punct('(') {
+			return nil, false, fmt.Errorf("INSERT into %s: expected a row at offset %d", table, t.pos)
+		}
+		var tuple []dumpValue
+		for {
+			v, err := parseDumpValue(raw, skipBlank(raw, t.pos))
+			if err != nil {
+				return nil, false, fmt.Errorf("INSERT into %s: %v", table, err)
+			}
+			tuple = append(tuple, v)
+			t.pos = v.end
+			if t.punct(')') {
+				break
+			}
+			if !t.punct(',') {
+				return nil, false, fmt.Errorf("INSERT into %s: expected a comma at offset %d", table, t.pos)
+			}
+		}
+		stmt.tuples = append(stmt.tuples, tuple)
+		if !t.punct(',') {
+			return stmt, true, nil
+		}
+	}
+}
+
+var dumpIntroducer = regexp.MustCompile(`^_[A-Za-z0-9]+\s*`)
+
+// parseDumpValue reads the value at i.
+func parseDumpValue(raw []byte, i int) (dumpValue, error) {
+	v := dumpValue{start: i}
+	rest := raw[i:]
+
+	// Strings may be preceded by a character set, such as _binary.
+	if m := dumpIntroducer.Find(rest); m != nil && len(rest) > len(m) &&
+		bytes.IndexByte([]byte("'xX0"), rest[len(m)]) >= 0 {
+		v.prefix = string(m)
+		i += len(m)
+		rest = raw[i:]
+	}
+
+	switch {
+	case len(rest) > 0 && rest[0] == '\'':
+		text, end, err := unquoteDumpString(raw, i)
+		if err != nil {
+			return v, err
+		}
+		v.kind, v.text, v.end = dumpString, text, end
+	case len(rest) > 2 && (rest[0] == 'x' || rest[0] == 'X') && rest[1] == '\'':
+		end := bytes.IndexByte(rest[2:], '\'')
+		if end < 0 {
+			return v, io.ErrUnexpectedEOF
+		}
+		b, err := hex.DecodeString(string(rest[2 : 2+end]))
+		if err != nil {
+			return v, err
+		}
+		v.kind, v.text, v.end = dumpHex, string(b), i+end+3
+	case len(rest) > 2 && rest[0] == '0' && (rest[1] == 'x' || rest[1] == 'X'):
+		end := 2
+		for end < len(rest) && isWordChar(rest[end]) {
+			end++
+		}
+		b, err := hex.DecodeString(string(rest[2:end]))
+		if err != nil {
+			return v, err
+		}
+		v.kind, v.text, v.end = dumpHex, string(b), i+end
+	default:
+		// Numbers, NULL and anything else are kept as they are,
+		// up to the end of the value.
+		i, v.prefix = v.start, ""
+		end := i
+		for end < len(raw) && raw[end] != ',' && raw[end] != ')' {
+			switch raw[end] {
+			case '(':
+				end = skipParens(raw, end)
+			case '\'', '"', '`':
+				end = skipQuoted(raw, end)
+			default:
+				end++
+			}
+		}
+		text := strings.TrimSpace(string(raw[i:end]))
+		v.kind, v.text, v.end = dumpOther, text, i+len(text)
+		if strings.EqualFold(text, "NULL") {
+			v.kind, v.text = dumpNull, ""
+		}
+	}
+	return v, nil
+}
+
+// unquoteDumpString decodes the MySQL string at i, returning its
+// content and the position following its closing quote.
+func unquoteDumpString(raw []byte, i int) (string, int, error) {
+	var b strings.Builder
+	for i++; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\\' && i+1 < len(raw):
+			i++
+			switch raw[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte(0x1a)
+			case '%', '_':
+				// Kept escaped, since they're escaped for LIKE patterns.
+				b.WriteByte('\\')
+				b.WriteByte(raw[i])
+			default:
+				b.WriteByte(raw[i])
+			}
+		case c == '\'':
+			if i+1 < len(raw) && raw[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", i, io.ErrUnexpectedEOF
+}
+
+// rewrite returns the raw statement with its changed values.
+func (stmt *dumpInsert) rewrite() []byte {
+	var b bytes.Buffer
+	last := 0
+	for _, tuple := range stmt.tuples {
+		for _, v := range tuple {
+			if !v.changed {
+				continue
+			}
+			b.Write(stmt.raw[last:v.start])
+			b.WriteString(v.prefix)
+			if v.kind == dumpHex {
+				b.WriteString(dumpHexLiteral(v.text))
+			} else {
+				b.WriteString(dumpQuote(v.text))
+			}
+			last = v.end
+		}
+	}
+	if last == 0 {
+		return stmt.raw
+	}
+	b.Write(stmt.raw[last:])
+	return b.Bytes()
+}
+
+func dumpHexLiteral(s string) string {
+	if s == "" {
+		return "''"
+	}
+	return "0x" + hex.EncodeToString([]byte(s))
+}
+
+// dumpQuote quotes a string the way mysqldump does.
+func dumpQuote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			// \% and \_ were kept escaped when unquoted.
+			if i+1 < len(s) && (s[i+1] == '%' || s[i+1] == '_') {
+				b.WriteByte('\\')
+				continue
+			}
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '"':
+			b.WriteString(`\"`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
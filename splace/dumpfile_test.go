@@ -0,0 +1,245 @@
+package splace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testDump = "-- MySQL dump\n" +
+	"/*!40101 SET NAMES utf8mb4 */;\n" +
+	"CREATE TABLE `wp_options` (\n" +
+	"  `option_id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `option_value` longtext NOT NULL,\n" +
+	"  `size` decimal(10,2) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`option_id`),\n" +
+	"  KEY `value` (`option_value`(10))\n" +
+	") ENGINE=InnoDB;\n" +
+	"INSERT INTO `wp_options` VALUES (1,'http://old.com',1.50),(2,'It\\'s; old.com\\n',NULL),(3,_binary 'old',0x6f6c642e636f6d);\n" +
+	"DELIMITER ;;\n" +
+	"CREATE TRIGGER t BEFORE INSERT ON wp_options FOR EACH ROW BEGIN SET NEW.option_value = 'old.com'; END ;;\n" +
+	"DELIMITER ;\n"
+
+func writeTestDump(t *testing.T) *DumpFile {
+	dir, err := ioutil.TempDir("", "splace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	name := filepath.Join(dir, "dump.sql")
+	if err := ioutil.WriteFile(name, []byte(testDump), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenDumpFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestDumpFileTables(t *testing.T) {
+	tables, err := writeTestDump(t).Tables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := TableMap{"wp_options": {
+		{Column: "option_id", Type: "bigint(20) unsigned"},
+		{Column: "option_value", Type: "longtext"},
+		{Column: "size", Type: "decimal(10,2)"},
+	}}
+	if !reflect.DeepEqual(tables, expected) {
+		t.Errorf("expected %v, got %v", expected, tables)
+	}
+}
+
+func TestDumpFileSearch(t *testing.T) {
+	f := writeTestDump(t)
+	tables, err := f.Tables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := f.Search(context.Background(), SearchOptions{Search: "%old.com_", Mode: Like, Tables: tables})
+	var rows [][]string
+	for done := false; !done; {
+		select {
+		case result := <-sr.Results():
+			for row := range result.Rows {
+				rows = append(rows, row)
+			}
+		case err := <-sr.Done():
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		}
+	}
+	expected := [][]string{{"2", "It's; old.com\n", ""}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %q, got %q", expected, rows)
+	}
+}
+
+func TestDumpFileCount(t *testing.T) {
+	f := writeTestDump(t)
+	tables, err := f.Tables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := f.Search(context.Background(), SearchOptions{
+		Search:      "old",
+		Mode:        Contains,
+		Tables:      tables,
+		Count:       true,
+		Occurrences: true,
+	})
+	var counts []*SearchCount
+	for done := false; !done; {
+		select {
+		case result := <-sr.Results():
+			for range result.Rows {
+				t.Error("expected no rows")
+			}
+			counts = append(counts, result.Count)
+		case err := <-sr.Done():
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		}
+	}
+	expected := []*SearchCount{{
+		Rows: 3,
+		Columns: []ColumnCount{
+			{Column: "option_id"},
+			{Column: "option_value", Rows: 3, Occurrences: 3},
+			{Column: "size", Rows: 1, Occurrences: 1},
+		},
+	}}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected %+v, got %+v", expected[0], counts[0])
+	}
+
+	sr = f.Search(context.Background(), SearchOptions{
+		Mode:        Contains,
+		Tables:      tables,
+		Count:       true,
+		Occurrences: true,
+	})
+	if err := <-sr.Done(); err != ErrEmptyOccurrences {
+		t.Errorf("expected %v, got %v", ErrEmptyOccurrences, err)
+	}
+}
+
+func TestDumpFileReplace(t *testing.T) {
+	f := writeTestDump(t)
+	tables, err := f.Tables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	r := f.Replace(context.Background(), ReplaceOptions{
+		Search:  "old",
+		Replace: "n'ew",
+		Mode:    Contains,
+		Tables:  tables,
+	}, &buf)
+	n := 0
+	for done := false; !done; {
+		select {
+		case result := <-r.Results():
+			for affected := range result.AffectedRows {
+				n += affected
+			}
+		case err := <-r.Done():
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		}
+	}
+	if n != 3 {
+		t.Errorf("expected 3 affected rows, got %d", n)
+	}
+	// Only the values change, while numbers and the trigger are left alone.
+	expected := bytes.Replace([]byte(testDump),
+		[]byte("(1,'http://old.com',1.50),(2,'It\\'s; old.com\\n',NULL),(3,_binary 'old',0x6f6c642e636f6d)"),
+		[]byte("(1,'http://n\\'ew.com',1.50),(2,'It\\'s; n\\'ew.com\\n',NULL),(3,_binary 'n\\'ew',0x6e2765772e636f6d)"), 1)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.Bytes())
+	}
+}
+
+func TestDumpFileReplaceMapping(t *testing.T) {
+	f := writeTestDump(t)
+	tables, err := f.Tables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMapping([]Pair{
+		{Search: "old.com", Replace: "new.org"},
+		{Search: "old", Replace: "new"},
+		{Search: "http://", Replace: "https://"},
+		{Search: "unused", Replace: "x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	r := f.Replace(context.Background(), ReplaceOptions{Mapping: m, Tables: tables}, &buf)
+	for done := false; !done; {
+		select {
+		case result := <-r.Results():
+			for range result.AffectedRows {
+			}
+		case err := <-r.Done():
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		}
+	}
+	expected := bytes.Replace([]byte(testDump),
+		[]byte("(1,'http://old.com',1.50),(2,'It\\'s; old.com\\n',NULL),(3,_binary 'old',0x6f6c642e636f6d)"),
+		[]byte("(1,'https://new.org',1.50),(2,'It\\'s; new.org\\n',NULL),(3,_binary 'new',0x6e65772e6f7267)"), 1)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.Bytes())
+	}
+	if hits := m.Hits(); !reflect.DeepEqual(hits, []int64{3, 1, 1, 0}) {
+		t.Errorf("expected hits [3 1 1 0], got %v", hits)
+	}
+
+	// Nothing counts if the replaced dump isn't written.
+	m, err = NewMapping(m.Pairs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = f.Replace(context.Background(), ReplaceOptions{Mapping: m, Tables: tables}, failingWriter{})
+	for done := false; !done; {
+		select {
+		case result := <-r.Results():
+			for range result.AffectedRows {
+			}
+		case err := <-r.Done():
+			if err != errWriteFailed {
+				t.Fatalf("expected %v, got %v", errWriteFailed, err)
+			}
+			done = true
+		}
+	}
+	if hits := m.Hits(); !reflect.DeepEqual(hits, []int64{0, 0, 0, 0}) {
+		t.Errorf("expected no hits, got %v", hits)
+	}
+}
+
+var errWriteFailed = errors.New("write failed")
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errWriteFailed
+}
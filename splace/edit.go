@@ -0,0 +1,162 @@
+package splace
+
+import (
+	"context"
+	"time"
+)
+
+// SeenRow is a row as it was seen, such as in search results, identified by
+// the values of the key columns of its table.
+type SeenRow struct {
+	Key []string
+
+	// Values holds the values of the columns as they were seen, by column name.
+	// Columns missing from Values are replaced as they are in the database.
+	Values map[string]string
+}
+
+// UpdateCell sets a column of the row with the given key values to value, as long
+// as it still holds old, the value it was seen with. NULL reads as empty, so an
+// empty old also matches a NULL cell. It returns ErrConflict if the value has
+// changed since, or the row is gone. The change is written to journal, if set,
+// so that it can be undone with Rollback, which leaves a NULL cell empty.
+func (s *Splace) UpdateCell(ctx context.Context, table, column string, key, keyValues []string, old, value string, journal *JournalWriter) error {
+	if len(key) == 0 || len(key) != len(keyValues) {
+		return ErrRowNotFound
+	}
+	if old == value {
+		// MySQL reports no affected rows for updates that change nothing.
+		return nil
+	}
+	r := newReplacer(ctx, s.db, ReplaceOptions{Journal: journal})
+	affected, err := r.updateRow(newQueryBuilder(s.db.Config().Engine), table, key, keyValues, []cellChange{{
+		column: column,
+		old:    old,
+		new:    value,
+		orNull: old == "",
+	}})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// ReplaceInRows makes the replacement only in the given rows of each table,
+// such as rows ticked in search results, in the columns of the table in
+// opt.Tables. The replacement is made in Go, in the values the rows were seen
+// with, and rows whose values have changed since are left as they are and
+// reported in ReplaceResult.Conflicts. Tables without a key can't be
+// replaced in by row. Limit is ignored.
+func (s *Splace) ReplaceInRows(ctx context.Context, opt ReplaceOptions, rows map[string][]SeenRow) *Replacer {
+	tables := TableMap{}
+	for table := range rows {
+		if columns, ok := opt.Tables[table]; ok {
+			tables[table] = columns
+		}
+	}
+	opt.Tables = tables
+	r := newReplacer(ctx, s.db, opt)
+	go func() {
+		defer close(r.results)
+		defer close(r.done)
+		r.done <- r.replaceInRows(rows)
+	}()
+	return r
+}
+
+func (r *Replacer) replaceInRows(rows map[string][]SeenRow) error {
+	replace, err := r.opt.valueReplacer()
+	if err != nil {
+		return err
+	}
+	if r.opt.Backup != nil {
+		if err := r.backup(); err != nil {
+			return err
+		}
+		r.backedUp = true
+	}
+	qb := newQueryBuilder(r.db.Config().Engine)
+	for table, columns := range r.opt.Tables {
+		cols, skipped := replacableColumns(qb.dialect(), columns)
+		if len(cols) == 0 {
+			if len(skipped) > 0 {
+				r.skipTable(table, skipped)
+			}
+			continue
+		}
+		if err := r.replaceInTableRows(qb, table, cols, skipped, rows[table], replace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceInTableRows makes the replacement in the given rows of a table, and sends
+// its result once they're done, since conflicts are only known by then.
+func (r *Replacer) replaceInTableRows(qb *queryBuilder, table string, columns []string, skipped []SkippedColumn, rows []SeenRow, replace func(string) string) error {
+	key, err := tableKey(r.ctx, r.db, qb.dialect(), table)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return ErrNoKey
+	}
+	start := time.Now()
+	n := 0
+	var conflicts [][]string
+	for _, row := range rows {
+		if len(row.Key) != len(key) {
+			return ErrRowNotFound
+		}
+		// Current values stand in for values that weren't seen,
+		// and tell whether the row is gone.
+		query, args := qb.selectRow(table, columns, key, row.Key)
+		current, err := fetchRows(r.ctx, r.db, query, args, 0)
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			conflicts = append(conflicts, row.Key)
+			continue
+		}
+		var changes []cellChange
+		for i, col := range columns {
+			old, ok := row.Values[col]
+			if !ok {
+				old = current[0].values[i]
+			}
+			if v := replace(old); v != old {
+				changes = append(changes, cellChange{column: col, old: old, new: v})
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		affected, err := r.updateRow(qb, table, key, row.Key, changes)
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			conflicts = append(conflicts, row.Key)
+		}
+		n += affected
+	}
+
+	affectedRows := make(chan int, 1)
+	if n > 0 {
+		affectedRows <- n
+	}
+	close(affectedRows)
+	r.results <- ReplaceResult{
+		Table:        table,
+		Schema:       tableSchema(table),
+		Skipped:      skipped,
+		Conflicts:    conflicts,
+		AffectedRows: affectedRows,
+		Start:        start,
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package splace
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// JournalEntry records a change to a single cell made by a replacement.
+type JournalEntry struct {
+	Table string
+
+	// Key holds the names of the key columns of the table and KeyValues
+	// the key of the changed row. Tables without a key aren't journaled.
+	Key       []string
+	KeyValues []string
+
+	Column string
+	Old    string
+	New    string
+}
+
+// JournalWriter writes a journal as gzipped JSON lines, one entry per line.
+type JournalWriter struct {
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func NewJournalWriter(w io.Writer) *JournalWriter {
+	gz := gzip.NewWriter(w)
+	return &JournalWriter{
+		gz:  gz,
+		enc: json.NewEncoder(gz),
+	}
+}
+
+func (j *JournalWriter) Write(entry JournalEntry) error {
+	return j.enc.Encode(entry)
+}
+
+// Close flushes the journal. It doesn't close the underlying writer.
+func (j *JournalWriter) Close() error {
+	return j.gz.Close()
+}
+
+// JournalReader reads a journal written by JournalWriter.
+type JournalReader struct {
+	dec *json.Decoder
+}
+
+func NewJournalReader(r io.Reader) (*JournalReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalReader{dec: json.NewDecoder(gz)}, nil
+}
+
+// Next returns the next entry of the journal, or io.EOF at its end.
+func (j *JournalReader) Next() (JournalEntry, error) {
+	var entry JournalEntry
+	err := j.dec.Decode(&entry)
+	return entry, err
+}
+
+type RollbackResult struct {
+	// Restored is the number of rows restored to their old values.
+	Restored int
+
+	// Conflicts are the changes that weren't rolled back, because the cell
+	// has been changed again since the replacement or its row is gone,
+	// or because the entry has no key to find its row by.
+	Conflicts []JournalEntry
+}
+
+// Rollback restores the old values recorded in a journal. Cells that no longer
+// hold the value they were replaced with are left alone and reported as conflicts.
+func (s *Splace) Rollback(ctx context.Context, journal io.Reader) (RollbackResult, error) {
+	var result RollbackResult
+	jr, err := NewJournalReader(journal)
+	if err != nil {
+		return result, err
+	}
+	qb := newQueryBuilder(s.db.Config().Engine)
+	for {
+		entry, err := jr.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if len(entry.Key) == 0 {
+			result.Conflicts = append(result.Conflicts, entry)
+			continue
+		}
+		query, args := qb.updateRow(entry.Table, entry.Key, entry.KeyValues, []cellChange{{
+			column: entry.Column,
+			old:    entry.New,
+			new:    entry.Old,
+		}})
+		res, err := s.db.Exec(ctx, query, args...)
+		if err != nil {
+			return result, err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return result, err
+		}
+		if rowsAffected == 0 {
+			result.Conflicts = append(result.Conflicts, entry)
+		}
+		result.Restored += int(rowsAffected)
+	}
+}
@@ -0,0 +1,43 @@
+package splace
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	entries := []JournalEntry{
+		{Table: "wp_options", Key: []string{"option_id"}, KeyValues: []string{"1"}, Column: "option_value", Old: "http://old.com", New: "http://new.com"},
+		{Table: "logs", Key: []string{"id"}, KeyValues: []string{"7"}, Column: "message", Old: "old.com\nis down", New: "new.com\nis down"},
+	}
+
+	var buf bytes.Buffer
+	w := NewJournalWriter(&buf)
+	for _, entry := range entries {
+		if err := w.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewJournalReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range entries {
+		entry, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(entry, expected) {
+			t.Errorf("entry %d: expected %+v, got %+v", i, expected, entry)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
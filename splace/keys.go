@@ -0,0 +1,35 @@
+package splace
+
+import (
+	"context"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+// tableKey returns the columns of the key identifying the rows of a table in key order:
+// the primary key, or else a unique key without nullable columns.
+// It returns nil if the table has neither.
+func tableKey(ctx context.Context, db querier.Querier, d dialect, table string) ([]string, error) {
+	rows, err := db.Query(ctx, d.keyQuery(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		name string
+		key  []string
+	)
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return nil, err
+		}
+		if key != nil && row[0] != name {
+			// Keys are listed best first, so the rest can be ignored.
+			break
+		}
+		name = row[0]
+		key = append(key, row[1])
+	}
+	return key, rows.Err()
+}
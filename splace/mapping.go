@@ -0,0 +1,258 @@
+package splace
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+var errEmptyMapping = errors.New("the mapping has no pairs")
+
+// Mapping replaces many searches with their replacements in a single pass over
+// each value, for mappings too large to nest in a query, such as thousands of
+// renamed URLs. Searches are matched as they are, whatever the Mode of the pairs.
+// Where searches overlap, the leftmost match wins, and of the matches starting
+// there the longest, so /blog/2019 is replaced before /blog.
+//
+// A Mapping counts the matches of each pair in the values that replacements
+// made with it have written, or in the changes a preview found.
+type Mapping struct {
+	pairs []Pair
+	hits  []int64
+
+	// The Aho-Corasick automaton of the searches, whose root is node 0.
+	// next holds the edges of the trie, keyed by node<<8 | byte.
+	next map[uint64]int32
+	fail []int32
+	// out is the pair whose search ends at a node, or -1, and outLink
+	// the nearest node down the failure links with a pair, or -1.
+	out     []int32
+	outLink []int32
+}
+
+// NewMapping builds a mapping from the searches and replacements of pairs.
+// Every search must be non-empty and appear once.
+func NewMapping(pairs []Pair) (*Mapping, error) {
+	if len(pairs) == 0 {
+		return nil, errEmptyMapping
+	}
+	m := &Mapping{
+		pairs: pairs,
+		hits:  make([]int64, len(pairs)),
+		next:  map[uint64]int32{},
+		fail:  []int32{0},
+		out:   []int32{-1},
+	}
+	// parents and via tell how each node was reached, and levels
+	// hold the nodes by depth, for building the failure links.
+	var (
+		parents = []int32{0}
+		via     = []byte{0}
+		levels  [][]int32
+	)
+	for i, p := range pairs {
+		if p.Search == "" {
+			return nil, fmt.Errorf("pair %d of the mapping has an empty search", i+1)
+		}
+		n := int32(0)
+		for j := 0; j < len(p.Search); j++ {
+			e := edge(n, p.Search[j])
+			child, ok := m.next[e]
+			if !ok {
+				child = int32(len(m.out))
+				m.next[e] = child
+				m.out = append(m.out, -1)
+				m.fail = append(m.fail, 0)
+				parents = append(parents, n)
+				via = append(via, p.Search[j])
+				if j == len(levels) {
+					levels = append(levels, nil)
+				}
+				levels[j] = append(levels[j], child)
+			}
+			n = child
+		}
+		if m.out[n] != -1 {
+			return nil, fmt.Errorf("%q is mapped more than once", p.Search)
+		}
+		m.out[n] = int32(i)
+	}
+
+	m.outLink = make([]int32, len(m.out))
+	m.outLink[0] = -1
+	for depth, level := range levels {
+		for _, n := range level {
+			if depth > 0 {
+				m.fail[n] = m.step(m.fail[parents[n]], via[n])
+			}
+			f := m.fail[n]
+			if m.out[f] != -1 {
+				m.outLink[n] = f
+			} else {
+				m.outLink[n] = m.outLink[f]
+			}
+		}
+	}
+	return m, nil
+}
+
+// ReadMapping reads a mapping from CSV, or from TSV with tsv, with a search and
+// its replacement in each record. TSV has no quoting, so a record is a line
+// whose two fields are split by its only tab. With header, the first record
+// is skipped.
+func ReadMapping(r io.Reader, tsv, header bool) (*Mapping, error) {
+	br := bufio.NewReader(r)
+	// Spreadsheets often save CSV with a byte order mark.
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	read := csvRecords(br)
+	if tsv {
+		read = tsvRecords(br)
+	}
+
+	var pairs []Pair
+	for {
+		record, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header {
+			header = false
+			continue
+		}
+		pairs = append(pairs, Pair{Search: record[0], Replace: record[1]})
+	}
+	return NewMapping(pairs)
+}
+
+// csvRecords returns a function reading the next record of two fields
+// from CSV, or io.EOF at the end.
+func csvRecords(r io.Reader) func() ([]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	return cr.Read
+}
+
+// tsvRecords is like csvRecords, but reads from TSV, skipping empty lines.
+func tsvRecords(r *bufio.Reader) func() ([]string, error) {
+	line := 0
+	return func() ([]string, error) {
+		for {
+			text, err := r.ReadString('\n')
+			if err != nil && (err != io.EOF || text == "") {
+				return nil, err
+			}
+			line++
+			text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+			if text == "" {
+				continue
+			}
+			record := strings.Split(text, "\t")
+			if len(record) != 2 {
+				return nil, fmt.Errorf("line %d has %d fields rather than 2", line, len(record))
+			}
+			return record, nil
+		}
+	}
+}
+
+func edge(n int32, c byte) uint64 {
+	return uint64(n)<<8 | uint64(c)
+}
+
+// step follows the edge of byte c from node n, falling back along
+// the failure links until a node has one.
+func (m *Mapping) step(n int32, c byte) int32 {
+	for {
+		if child, ok := m.next[edge(n, c)]; ok {
+			return child
+		}
+		if n == 0 {
+			return 0
+		}
+		n = m.fail[n]
+	}
+}
+
+// Pairs returns the pairs of the mapping, in the order they were given.
+func (m *Mapping) Pairs() []Pair {
+	return m.pairs
+}
+
+// Hits returns the number of matches replaced of each pair so far,
+// in the order of Pairs.
+func (m *Mapping) Hits() []int64 {
+	hits := make([]int64, len(m.hits))
+	for i := range m.hits {
+		hits[i] = atomic.LoadInt64(&m.hits[i])
+	}
+	return hits
+}
+
+// addHits adds hits counted by apply to those of the mapping.
+func (m *Mapping) addHits(hits []int64) {
+	for i, n := range hits {
+		atomic.AddInt64(&m.hits[i], n)
+	}
+}
+
+// replace replaces the matches of the searches in a value.
+func (m *Mapping) replace(s string) string {
+	return m.apply(s, nil)
+}
+
+// apply replaces the matches of the searches in a value, and counts them
+// into hits, in the order of Pairs, unless hits is nil.
+func (m *Mapping) apply(s string, hits []int64) string {
+	// longest holds, for each byte offset, 1 + the pair with the
+	// longest search starting there, or 0.
+	var longest []int32
+	n := int32(0)
+	for i := 0; i < len(s); i++ {
+		n = m.step(n, s[i])
+		o := n
+		if m.out[o] == -1 {
+			o = m.outLink[o]
+		}
+		for ; o != -1; o = m.outLink[o] {
+			p := m.out[o]
+			start := i + 1 - len(m.pairs[p].Search)
+			if longest == nil {
+				longest = make([]int32, len(s))
+			}
+			if cur := longest[start]; cur == 0 || len(m.pairs[cur-1].Search) < len(m.pairs[p].Search) {
+				longest[start] = p + 1
+			}
+		}
+	}
+	if longest == nil {
+		return s
+	}
+
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(s); {
+		p := longest[i] - 1
+		if p == -1 {
+			i++
+			continue
+		}
+		b.WriteString(s[last:i])
+		b.WriteString(m.pairs[p].Replace)
+		if hits != nil {
+			atomic.AddInt64(&hits[p], 1)
+		}
+		i += len(m.pairs[p].Search)
+		last = i
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
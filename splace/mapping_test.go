@@ -0,0 +1,85 @@
+package splace
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMapping(t *testing.T) {
+	m, err := NewMapping([]Pair{
+		{Search: "/blog", Replace: "/news"},
+		{Search: "/blog/2019", Replace: "/archive"},
+		{Search: "g/20", Replace: "!"},
+		{Search: "é", Replace: "e"},
+		{Search: "unused", Replace: "x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in  string
+		out string
+	}{
+		// The longest search starting at a position wins.
+		{"see /blog/2019/a and /blog/2020", "see /archive/a and /news/2020"},
+		// Of overlapping matches, the leftmost wins.
+		{"blog/2019", "blo!19"},
+		{"café /blog", "cafe /news"},
+		{"nothing here", "nothing here"},
+	}
+	for _, test := range tests {
+		if out := m.apply(test.in, m.hits); out != test.out {
+			t.Errorf("%q: expected %q, got %q", test.in, test.out, out)
+		}
+	}
+	expected := []int64{2, 1, 1, 1, 0}
+	if hits := m.Hits(); !reflect.DeepEqual(hits, expected) {
+		t.Errorf("expected hits %v, got %v", expected, hits)
+	}
+
+	if _, err := NewMapping([]Pair{{Search: "a", Replace: "b"}, {Search: "a", Replace: "c"}}); err == nil {
+		t.Error("expected an error for a search mapped twice")
+	}
+	if _, err := NewMapping([]Pair{{Search: "", Replace: "b"}}); err == nil {
+		t.Error("expected an error for an empty search")
+	}
+}
+
+func TestReadMapping(t *testing.T) {
+	tests := []struct {
+		in     string
+		tsv    bool
+		header bool
+		pairs  []Pair
+	}{
+		{
+			"\xef\xbb\xbfold,new\n\"a,b\",c\n\"d\te\",f\n",
+			false,
+			true,
+			[]Pair{{Search: "a,b", Replace: "c"}, {Search: "d\te", Replace: "f"}},
+		},
+		{
+			"a,b\tc\r\n\n12\" pipe\t\"e\"\n",
+			true,
+			false,
+			[]Pair{{Search: "a,b", Replace: "c"}, {Search: "12\" pipe", Replace: "\"e\""}},
+		},
+	}
+	for _, test := range tests {
+		m, err := ReadMapping(strings.NewReader(test.in), test.tsv, test.header)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(m.Pairs(), test.pairs) {
+			t.Errorf("%q: expected %+v, got %+v", test.in, test.pairs, m.Pairs())
+		}
+	}
+	if _, err := ReadMapping(strings.NewReader("a,b,c\n"), false, false); err == nil {
+		t.Error("expected an error for a record with three fields")
+	}
+	if _, err := ReadMapping(strings.NewReader("a\tb\nc\n"), true, false); err == nil {
+		t.Error("expected an error for a line without a tab")
+	}
+}
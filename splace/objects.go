@@ -0,0 +1,428 @@
+package splace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+// Kinds of the objects searched with the Objects option, in SearchResult.Object
+// and ReplaceResult.Object.
+const (
+	ObjectView      = "VIEW"
+	ObjectProcedure = "PROCEDURE"
+	ObjectFunction  = "FUNCTION"
+	ObjectTrigger   = "TRIGGER"
+	ObjectEvent     = "EVENT"
+)
+
+var (
+	ErrUnsupportedObjects = errors.New("views, routines, triggers and events are only supported for MySQL")
+
+	errNoDelimiter = errors.New("no delimiter fits the definition")
+)
+
+// object is a view, routine, trigger or event whose definition matches the search.
+type object struct {
+	schema string
+	name   string
+	kind   string
+	// table is the name of the result, qualified as schema.name
+	// when the selected tables are qualified.
+	table string
+	// definition is the body of the object, without its CREATE statement.
+	definition string
+	// orReplace is set when the server replaces routines, triggers and events
+	// with CREATE OR REPLACE, as MariaDB does.
+	orReplace bool
+}
+
+// objectsQuery lists the views, routines, triggers and events of the schemas
+// whose definitions match the search of any of the pairs, or all of them
+// with an empty search.
+func objectsQuery(d mysqlDialect, schemas []string, pairs []Pair) string {
+	in := "IN (" + literalList(d, schemas) + ")"
+	q := `SELECT * FROM (` +
+		`SELECT TABLE_SCHEMA AS SCHEMA_NAME, TABLE_NAME AS NAME, 'VIEW' AS KIND, VIEW_DEFINITION AS DEFINITION ` +
+		`FROM INFORMATION_SCHEMA.VIEWS WHERE TABLE_SCHEMA ` + in + ` UNION ALL ` +
+		`SELECT ROUTINE_SCHEMA, ROUTINE_NAME, ROUTINE_TYPE, ROUTINE_DEFINITION ` +
+		`FROM INFORMATION_SCHEMA.ROUTINES WHERE ROUTINE_SCHEMA ` + in + ` UNION ALL ` +
+		`SELECT TRIGGER_SCHEMA, TRIGGER_NAME, 'TRIGGER', ACTION_STATEMENT ` +
+		`FROM INFORMATION_SCHEMA.TRIGGERS WHERE TRIGGER_SCHEMA ` + in + ` UNION ALL ` +
+		`SELECT EVENT_SCHEMA, EVENT_NAME, 'EVENT', EVENT_DEFINITION ` +
+		`FROM INFORMATION_SCHEMA.EVENTS WHERE EVENT_SCHEMA ` + in +
+		`) AS o `
+	var matches []string
+	for _, p := range pairs {
+		if p.Search == "" {
+			// An empty search matches every object.
+			matches = nil
+			break
+		}
+		matches = append(matches, d.match("o.DEFINITION", p.Search, p.Mode))
+	}
+	if len(matches) > 0 {
+		q += `WHERE ` + strings.Join(matches, " OR ") + ` `
+	}
+	return q + `ORDER BY o.SCHEMA_NAME, o.KIND, o.NAME`
+}
+
+// findObjects finds the objects whose definitions match any of the pairs in the
+// schemas of the selected tables, which are the current database for
+// unqualified tables.
+func findObjects(ctx context.Context, db querier.Querier, tables TableMap, pairs []Pair) ([]object, error) {
+	if db.Config().Engine != querier.MySQL {
+		return nil, ErrUnsupportedObjects
+	}
+	current, err := currentDatabase(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	orReplace, err := createOrReplace(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	qualified := map[string]bool{}
+	for table := range tables {
+		schema, _ := splitTable(table)
+		qualified[schema] = true
+	}
+	if len(qualified) == 0 {
+		qualified[""] = true
+	}
+	var schemas []string
+	for schema := range qualified {
+		if schema == "" {
+			schema = current
+		}
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+
+	rows, err := db.Query(ctx, objectsQuery(mysqlDialect{}, schemas, pairs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var objects []object
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return nil, err
+		}
+		o := object{schema: row[0], name: row[1], kind: row[2], table: row[1], definition: row[3], orReplace: orReplace}
+		if o.schema != current || !qualified[""] {
+			o.table = o.schema + "." + o.name
+		}
+		objects = append(objects, o)
+	}
+	return objects, rows.Err()
+}
+
+// createOrReplace reports whether the server supports CREATE OR REPLACE for
+// routines, triggers and events, which MariaDB does since 10.1.4.
+func createOrReplace(ctx context.Context, db querier.Querier) (bool, error) {
+	rows, err := db.Query(ctx, "SELECT VERSION()")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var version string
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return false, err
+		}
+		version = row[0]
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	return mariaDBReplaces(version), nil
+}
+
+// mariaDBReplaces reports whether the version, as told by VERSION(), is that
+// of a MariaDB with CREATE OR REPLACE for routines, triggers and events.
+func mariaDBReplaces(version string) bool {
+	if !strings.Contains(version, "MariaDB") {
+		return false
+	}
+	var major, minor, patch int
+	fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch)
+	return major > 10 || major == 10 && (minor > 1 || minor == 1 && patch >= 4)
+}
+
+// currentDatabase returns the default database of the connection.
+func currentDatabase(ctx context.Context, db querier.Querier) (string, error) {
+	rows, err := db.Query(ctx, "SELECT DATABASE()")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var name string
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return "", err
+		}
+		name = row[0]
+	}
+	return name, rows.Err()
+}
+
+// searchObjects sends a result for each object matching the search,
+// with its definition as the only row, or counted with SearchOptions.Count,
+// or as the only match with SearchOptions.Snippets.
+func (s *Searcher) searchObjects() error {
+	objects, err := findObjects(s.ctx, s.db, s.opt.Tables, []Pair{{Search: s.opt.Search, Mode: s.opt.Mode}})
+	if err != nil {
+		return err
+	}
+	var find func(string) [][]int
+	if s.opt.Snippets > 0 && !s.opt.Count {
+		if find, err = s.opt.matchFinder(s.db.Config().Engine); err != nil {
+			return err
+		}
+	}
+	for _, o := range objects {
+		rows := make(chan []string, 1)
+		var (
+			count   *SearchCount
+			matches chan MatchRow
+		)
+		if find != nil {
+			matches = make(chan MatchRow, 1)
+			matches <- s.matchRow([]string{o.definition}, 0, []string{"Definition"}, find)
+			close(matches)
+		} else if s.opt.Count {
+			c := ColumnCount{Column: "Definition", Rows: 1}
+			if s.opt.Occurrences {
+				c.Occurrences = 1
+				if s.opt.Mode == Contains {
+					c.Occurrences = int64(strings.Count(o.definition, s.opt.Search))
+				}
+			}
+			count = &SearchCount{Rows: 1, Columns: []ColumnCount{c}}
+		} else {
+			rows <- []string{o.definition}
+		}
+		close(rows)
+		s.results <- SearchResult{
+			Table:   o.table,
+			Schema:  tableSchema(o.table),
+			Object:  o.kind,
+			Columns: []string{"Definition"},
+			Rows:    rows,
+			Matches: matches,
+			Count:   count,
+			Start:   time.Now(),
+		}
+	}
+	return nil
+}
+
+// objectCreate is the CREATE statement of an object, as told by SHOW CREATE,
+// along with the session variables it was created with.
+type objectCreate struct {
+	object
+	create string
+	// vars are the session variables to set around the statement, in order.
+	vars [][2]string
+}
+
+// showCreate reads the CREATE statement of an object.
+func showCreate(ctx context.Context, db querier.Querier, o object) (*objectCreate, error) {
+	d := mysqlDialect{}
+	rows, err := db.Query(ctx, "SHOW CREATE "+o.kind+" "+d.quote(o.schema)+"."+d.quote(o.name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	c := &objectCreate{object: o}
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return nil, err
+		}
+		for i, col := range columns {
+			switch {
+			case col == "sql_mode", col == "time_zone",
+				col == "character_set_client", col == "collation_connection":
+				c.vars = append(c.vars, [2]string{col, row[i]})
+			case strings.HasPrefix(col, "Create "), col == "SQL Original Statement":
+				c.create = row[i]
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if c.create == "" {
+		return nil, fmt.Errorf("the definition of %s %s isn't visible to the user", strings.ToLower(o.kind), o.table)
+	}
+	return c, nil
+}
+
+// replaced returns the CREATE statement of the object with the definition
+// replaced by replace, or false if the definition doesn't change.
+func (c *objectCreate) replaced(replace func(string) string) (*objectCreate, bool, error) {
+	definition := replace(c.definition)
+	if definition == c.definition {
+		return nil, false, nil
+	}
+	// The definition comes last, after the name and the DEFINER
+	// clause, which the replacement leaves as they are.
+	i := strings.LastIndex(c.create, c.definition)
+	if i == -1 {
+		return nil, false, fmt.Errorf("can't find the definition of %s %s in its CREATE statement", strings.ToLower(c.kind), c.table)
+	}
+	r := *c
+	r.definition = definition
+	r.create = c.create[:i] + definition + c.create[i+len(c.definition):]
+	return &r, true, nil
+}
+
+// writeScript writes the statements recreating the object with the session
+// variables it was created with, in the style of mysqldump.
+// The variables are set back only when the script runs to its end, so it's
+// run with Restore, which doesn't return its connection to the pool.
+//
+// Views, and other objects in MariaDB, are replaced in a single statement.
+// MySQL can only drop routines, triggers and events and create them again,
+// so the object is missing until the CREATE statement succeeds, and the
+// grants on a routine, which SHOW CREATE doesn't tell, are lost.
+func (c *objectCreate) writeScript(w io.Writer) error {
+	d := mysqlDialect{}
+	var b strings.Builder
+	b.WriteString("USE " + d.quote(c.schema) + ";\n")
+	if len(c.vars) > 0 {
+		saved := make([]string, len(c.vars))
+		set := make([]string, len(c.vars))
+		for i, v := range c.vars {
+			saved[i] = "@saved_" + v[0] + " = @@" + v[0]
+			set[i] = v[0] + " = " + d.literal(v[1])
+		}
+		b.WriteString("SET " + strings.Join(saved, ", ") + ";\n")
+		b.WriteString("SET " + strings.Join(set, ", ") + ";\n")
+	}
+	if c.kind == ObjectView {
+		b.WriteString("CREATE OR REPLACE " + strings.TrimPrefix(c.create, "CREATE ") + ";\n")
+	} else {
+		delimiter := ""
+		for _, s := range []string{";;", "$$", "//", "~~"} {
+			if !strings.Contains(c.create, s) {
+				delimiter = s
+				break
+			}
+		}
+		if delimiter == "" {
+			return errNoDelimiter
+		}
+		create := c.create
+		if c.orReplace && strings.HasPrefix(create, "CREATE ") {
+			create = "CREATE OR REPLACE " + strings.TrimPrefix(create, "CREATE ")
+		} else {
+			// MySQL commits before and after every DDL statement, so the old object
+			// is dropped and the new one created in separate transactions.
+			b.WriteString("DROP " + c.kind + " IF EXISTS " + d.quote(c.name) + ";\n")
+		}
+		b.WriteString("DELIMITER " + delimiter + "\n")
+		b.WriteString(create + " " + delimiter + "\n")
+		b.WriteString("DELIMITER ;\n")
+	}
+	if len(c.vars) > 0 {
+		restore := make([]string, len(c.vars))
+		for i, v := range c.vars {
+			restore[i] = v[0] + " = @saved_" + v[0]
+		}
+		b.WriteString("SET " + strings.Join(restore, ", ") + ";\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// script returns the statements recreating the object.
+func (c *objectCreate) script() (string, error) {
+	var b strings.Builder
+	err := c.writeScript(&b)
+	return b.String(), err
+}
+
+// backupObjects writes the statements recreating the objects to the backup.
+func (r *Replacer) backupObjects(objects []object) error {
+	for _, o := range objects {
+		c, err := showCreate(r.ctx, r.db, o)
+		if err != nil {
+			return err
+		}
+		if err := c.writeScript(r.opt.Backup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceObjects recreates the objects with the replacement made in their
+// definitions. When recreating an object fails, it's recreated as it was.
+func (r *Replacer) replaceObjects(objects []object) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	replace, err := r.opt.valueReplacer()
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		old, err := showCreate(r.ctx, r.db, o)
+		if err != nil {
+			return err
+		}
+		c, changed, err := old.replaced(replace)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		oldScript, err := old.script()
+		if err != nil {
+			return err
+		}
+		script, err := c.script()
+		if err != nil {
+			return err
+		}
+
+		affectedRows := make(chan int, 1)
+		r.results <- ReplaceResult{
+			Table:        o.table,
+			Schema:       tableSchema(o.table),
+			Object:       o.kind,
+			SQL:          c.create,
+			AffectedRows: affectedRows,
+			Start:        time.Now(),
+		}
+		err = r.db.Restore(r.ctx, strings.NewReader(script))
+		if err != nil {
+			close(affectedRows)
+			if restoreErr := r.db.Restore(context.Background(), strings.NewReader(oldScript)); restoreErr != nil {
+				return fmt.Errorf("%v, and recreating %s %s as it was failed: %v", err, strings.ToLower(o.kind), o.table, restoreErr)
+			}
+			return err
+		}
+		r.opt.countHits(old.definition)
+		affectedRows <- 1
+		close(affectedRows)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package splace
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestObjectScript(t *testing.T) {
+	c := &objectCreate{
+		object: object{
+			schema:     "wp",
+			name:       "urls",
+			kind:       ObjectProcedure,
+			table:      "urls",
+			definition: "BEGIN SELECT 'http://old.com;;'; END",
+		},
+		create: "CREATE DEFINER=`old.com`@`%` PROCEDURE `urls`()\nBEGIN SELECT 'http://old.com;;'; END",
+		vars:   [][2]string{{"sql_mode", "NO_ENGINE_SUBSTITUTION"}},
+	}
+	r, changed, err := c.replaced(func(s string) string {
+		return strings.Replace(s, "old.com", "new.com", -1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("expected the definition to change")
+	}
+	script, err := r.script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The definer is left as it is, and the delimiter avoids the definition.
+	expected := "USE `wp`;\n" +
+		"SET @saved_sql_mode = @@sql_mode;\n" +
+		"SET sql_mode = 'NO_ENGINE_SUBSTITUTION';\n" +
+		"DROP PROCEDURE IF EXISTS `urls`;\n" +
+		"DELIMITER $$\n" +
+		"CREATE DEFINER=`old.com`@`%` PROCEDURE `urls`()\nBEGIN SELECT 'http://new.com;;'; END $$\n" +
+		"DELIMITER ;\n" +
+		"SET sql_mode = @saved_sql_mode;\n"
+	if script != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, script)
+	}
+
+	if _, changed, _ := c.replaced(func(s string) string { return s }); changed {
+		t.Error("expected the definition not to change")
+	}
+
+	view := &objectCreate{
+		object: object{schema: "wp", name: "v", kind: ObjectView, definition: "select 'old.com'"},
+		create: "CREATE ALGORITHM=UNDEFINED VIEW `v` AS select 'old.com'",
+	}
+	script, err = view.script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "USE `wp`;\nCREATE OR REPLACE ALGORITHM=UNDEFINED VIEW `v` AS select 'old.com';\n"
+	if script != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, script)
+	}
+	// MariaDB replaces the trigger in place, keeping it until the new one is created.
+	trigger := &objectCreate{
+		object: object{schema: "wp", name: "t", kind: ObjectTrigger, definition: "SET NEW.url = 'old.com'", orReplace: true},
+		create: "CREATE DEFINER=`root`@`%` TRIGGER `t` BEFORE INSERT ON `links` FOR EACH ROW SET NEW.url = 'old.com'",
+	}
+	script, err = trigger.script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "USE `wp`;\n" +
+		"DELIMITER ;;\n" +
+		"CREATE OR REPLACE DEFINER=`root`@`%` TRIGGER `t` BEFORE INSERT ON `links` FOR EACH ROW SET NEW.url = 'old.com' ;;\n" +
+		"DELIMITER ;\n"
+	if script != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, script)
+	}
+}
+
+func TestMariaDBReplaces(t *testing.T) {
+	tests := []struct {
+		version  string
+		replaces bool
+	}{
+		{"8.0.36", false},
+		{"5.7.44-log", false},
+		{"10.0.38-MariaDB", false},
+		{"10.1.3-MariaDB", false},
+		{"10.1.4-MariaDB", true},
+		{"10.6.12-MariaDB-1:10.6.12+maria~ubu2004", true},
+		{"11.4.2-MariaDB", true},
+	}
+	for _, test := range tests {
+		if replaces := mariaDBReplaces(test.version); replaces != test.replaces {
+			t.Errorf("%s: expected %v, got %v", test.version, test.replaces, replaces)
+		}
+	}
+}
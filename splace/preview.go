@@ -0,0 +1,197 @@
+package splace
+
+import (
+	"context"
+	"time"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+type PreviewResult struct {
+	Table string
+	// Schema is the schema of a table qualified as schema.table, or empty.
+	Schema string
+
+	SQL string
+
+	// Key holds the names of the columns identifying the rows of the table,
+	// or nil if the table has no primary key or unique key.
+	Key []string
+
+	// Rows transmits the rows that would change, as they are found.
+	// Rows is closed when we're done previewing this table.
+	Rows <-chan PreviewRow
+
+	Start time.Time
+}
+
+// PreviewRow is a row that would change by a replacement.
+type PreviewRow struct {
+	// Key holds the values of the key columns of the row.
+	Key []string
+
+	Changes []PreviewChange
+}
+
+// PreviewChange is the replacement of a single column of a row.
+type PreviewChange struct {
+	Column string
+	Old    string
+	New    string
+
+	// Edits are the character-level differences between Old and New.
+	Edits []Edit
+}
+
+// PreviewTotals sums up the changes a replacement would make.
+type PreviewTotals struct {
+	Tables  int
+	Rows    int
+	Columns int
+	Edits   int
+}
+
+// Previewer finds the rows a replacement would change, without changing them.
+// Replacements are made in Go, so a regexp replacement may differ slightly
+// from that of the database.
+type Previewer struct {
+	ctx context.Context
+	db  querier.Querier
+	opt ReplaceOptions
+
+	totals  PreviewTotals
+	results chan PreviewResult
+	done    chan error
+}
+
+func newPreviewer(ctx context.Context, db querier.Querier, opt ReplaceOptions) *Previewer {
+	return &Previewer{
+		ctx:     ctx,
+		db:      db,
+		opt:     opt,
+		results: make(chan PreviewResult, 128),
+		done:    make(chan error),
+	}
+}
+
+func (p *Previewer) start() {
+	defer close(p.results)
+	defer close(p.done)
+	p.done <- p.preview()
+}
+
+func (p *Previewer) preview() error {
+	qb := newQueryBuilder(p.db.Config().Engine)
+	if !p.opt.supports(qb.dialect(), false) {
+		return ErrUnsupportedMode
+	}
+	replace, err := p.opt.valueReplacer()
+	if err != nil {
+		return err
+	}
+
+	for table, columns := range p.opt.Tables {
+		cols, _ := replacableColumns(qb.dialect(), columns)
+		if len(cols) == 0 {
+			continue
+		}
+		if err := p.previewTable(qb, table, cols, replace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Previewer) previewTable(qb *queryBuilder, table string, columns []string, replace func(string) string) error {
+	key, err := tableKey(p.ctx, p.db, qb.dialect(), table)
+	if err != nil {
+		return err
+	}
+	opt := queryOptions{
+		table:    table,
+		columns:  columns,
+		limit:    p.opt.Limit,
+		pairs:    p.opt.pairs(),
+		everyRow: p.opt.Mapping != nil,
+	}
+	if opt.limit == 0 {
+		opt.limit = defaultRowLimit
+	}
+	if len(key) == 0 {
+		opt.limit = 0
+	}
+	query, args := qb.selectKeyed(opt, key, nil)
+
+	previews := make(chan PreviewRow, 128)
+	defer close(previews)
+
+	p.results <- PreviewResult{
+		Table:  table,
+		Schema: tableSchema(table),
+		SQL:    query,
+		Key:    key,
+		Rows:   previews,
+		Start:  time.Now(),
+	}
+
+	changed := false
+	for {
+		rows, err := fetchRows(p.ctx, p.db, query, args, len(key))
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			var changes []PreviewChange
+			for i, col := range columns {
+				v := replace(row.values[i])
+				if v == row.values[i] {
+					continue
+				}
+				p.opt.countHits(row.values[i])
+				edits := diff(row.values[i], v)
+				changes = append(changes, PreviewChange{
+					Column: col,
+					Old:    row.values[i],
+					New:    v,
+					Edits:  edits,
+				})
+				p.totals.Columns++
+				p.totals.Edits += len(edits)
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			p.totals.Rows++
+			changed = true
+
+			select {
+			case previews <- PreviewRow{Key: row.key, Changes: changes}:
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+		}
+
+		if opt.limit == 0 || len(rows) < opt.limit {
+			break
+		}
+		query, args = qb.selectKeyed(opt, key, rows[len(rows)-1].key)
+	}
+	if changed {
+		p.totals.Tables++
+	}
+	return nil
+}
+
+func (p *Previewer) Results() <-chan PreviewResult {
+	return p.results
+}
+
+func (p *Previewer) Done() <-chan error {
+	return p.done
+}
+
+// Totals returns the totals of the preview once Done transmits.
+func (p *Previewer) Totals() PreviewTotals {
+	return p.totals
+}
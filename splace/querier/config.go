@@ -1,6 +1,10 @@
 package querier
 
 import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
 	"github.com/go-sql-driver/mysql"
 )
 
@@ -10,9 +14,14 @@ type Config struct {
 	User     string
 	Pwd      string
 	Database string
+
+	// Params holds additional driver parameters, such as sslmode for PostgreSQL.
+	Params map[string]string `json:",omitempty"`
 }
 
-func parseDSN(engine Engine, dataSourceName string) (*Config, error) {
+// ParseDSN parses a data source name in the format of the engine's driver, or a file path
+// for SQLite. It's the reverse of Config.String.
+func ParseDSN(engine Engine, dataSourceName string) (*Config, error) {
 	switch engine {
 	case MySQL:
 		c, err := mysql.ParseDSN(dataSourceName)
@@ -25,6 +34,67 @@ func parseDSN(engine Engine, dataSourceName string) (*Config, error) {
 			User:     c.User,
 			Pwd:      c.Passwd,
 			Database: c.DBName,
+			Params:   c.Params,
+		}, nil
+	case PostgreSQL:
+		u, err := url.Parse(dataSourceName)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return nil, ErrInvalidDSN
+		}
+		c := &Config{
+			Engine:   PostgreSQL,
+			Addr:     u.Host,
+			Database: strings.TrimPrefix(u.Path, "/"),
+		}
+		if u.User != nil {
+			c.User = u.User.Username()
+			c.Pwd, _ = u.User.Password()
+		}
+		for k, v := range u.Query() {
+			if c.Params == nil {
+				c.Params = map[string]string{}
+			}
+			c.Params[k] = v[0]
+		}
+		return c, nil
+	case SQLServer:
+		u, err := url.Parse(dataSourceName)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "sqlserver" {
+			return nil, ErrInvalidDSN
+		}
+		c := &Config{
+			Engine: SQLServer,
+			Addr:   u.Host,
+		}
+		if u.User != nil {
+			c.User = u.User.Username()
+			c.Pwd, _ = u.User.Password()
+		}
+		for k, v := range u.Query() {
+			if k == "database" {
+				c.Database = v[0]
+				continue
+			}
+			if c.Params == nil {
+				c.Params = map[string]string{}
+			}
+			c.Params[k] = v[0]
+		}
+		return c, nil
+	case SQLite:
+		path := strings.TrimPrefix(dataSourceName, "file:")
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+		return &Config{
+			Engine:   SQLite,
+			Database: path,
 		}, nil
 	}
 	return nil, ErrUnsupportedEngine
@@ -39,7 +109,45 @@ func (c Config) String() (string, error) {
 		cfg.User = c.User
 		cfg.Passwd = c.Pwd
 		cfg.DBName = c.Database
+		cfg.Params = c.Params
 		return cfg.FormatDSN(), nil
+	case PostgreSQL:
+		u := url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(c.User, c.Pwd),
+			Host:   c.Addr,
+			Path:   "/" + c.Database,
+		}
+		q := url.Values{}
+		for k, v := range c.Params {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	case SQLServer:
+		q := url.Values{}
+		for k, v := range c.Params {
+			q.Set(k, v)
+		}
+		q.Set("database", c.Database)
+		u := url.URL{
+			Scheme:   "sqlserver",
+			User:     url.UserPassword(c.User, c.Pwd),
+			Host:     c.Addr,
+			RawQuery: q.Encode(),
+		}
+		return u.String(), nil
+	case SQLite:
+		// Database is the path of the database file. Opening it read-write
+		// prevents SQLite from silently creating a missing file, and a case
+		// sensitive LIKE matches the other engines.
+		q := url.Values{}
+		q.Set("mode", "rw")
+		q.Set("_cslike", "1")
+		for k, v := range c.Params {
+			q.Set(k, v)
+		}
+		return "file:" + filepath.ToSlash(c.Database) + "?" + q.Encode(), nil
 	}
 	return "", ErrUnsupportedEngine
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"os"
 )
 
 type Direct struct {
@@ -13,11 +14,7 @@ type Direct struct {
 }
 
 func NewDirect(cfg Config) (*Direct, error) {
-	dsn, err := cfg.String()
-	if err != nil {
-		return nil, err
-	}
-	db, err := sql.Open(string(cfg.Engine), dsn)
+	db, err := openDB(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -27,6 +24,22 @@ func NewDirect(cfg Config) (*Direct, error) {
 	}, nil
 }
 
+// openDB opens a pool of connections to the database of cfg.
+func openDB(cfg Config) (*sql.DB, error) {
+	dsn, err := cfg.String()
+	if err != nil {
+		return nil, err
+	}
+	driver := string(cfg.Engine)
+	if cfg.Engine == SQLite {
+		if _, err := os.Stat(cfg.Database); err != nil {
+			return nil, err
+		}
+		driver = sqliteDriver
+	}
+	return sql.Open(driver, dsn)
+}
+
 func (d *Direct) DiscoveredConfigs() []DiscoveredConfig {
 	return nil
 }
@@ -40,14 +53,55 @@ func (d *Direct) Query(ctx context.Context, query string, args ...interface{}) (
 	return &directRows{Rows: rows}, err
 }
 
-func (d *Direct) Dump(ctx context.Context, w io.Writer) error {
+func (d *Direct) Dump(ctx context.Context, w io.Writer, opt DumpOptions) error {
 	switch d.cfg.Engine {
 	case MySQL:
-		return mysqldump(ctx, d.cfg, w)
+		return mysqldump(ctx, d.db, d.cfg.Database, w, opt)
+	case SQLite:
+		// The main schema is the database itself, while others are attached.
+		if opt.Schema != "" && opt.Schema != "main" {
+			return errUnsupportedSchema
+		}
+		return sqlitedump(ctx, d.db, w, opt)
 	}
 	return fmt.Errorf("dumps for %s are not supported yet", d.cfg.Engine)
 }
 
+func (d *Direct) Restore(ctx context.Context, r io.Reader) error {
+	// Dumps change session variables, such as FOREIGN_KEY_CHECKS, SQL_MODE
+	// and the default database, so the statements run on a connection of
+	// their own, which is closed afterwards rather than returned to the pool,
+	// along with any transaction a failed statement left open.
+	db, err := openDB(d.cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r, err = Decompress(r)
+	if err != nil {
+		return err
+	}
+	scanner := NewStatementScanner(r, d.cfg.Engine)
+	for {
+		stmt, err := scanner.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+}
+
 func (d *Direct) Config() Config {
 	return d.cfg
 }
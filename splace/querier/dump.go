@@ -1,47 +1,375 @@
 package querier
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"net"
-	"os/exec"
-	"path/filepath"
+	"path"
+	"strings"
 )
 
-type dumpError struct {
-	err    error
-	output []byte
+var (
+	errNoTablesToDump    = errors.New("no tables to dump")
+	errUnsupportedSchema = errors.New("dumps of other schemas are only supported by direct MySQL connections")
+)
+
+type DumpOptions struct {
+	// Schema is the schema (database) to dump instead of the connected one.
+	// The dump begins with a USE statement, so it restores to the same schema.
+	// Only direct MySQL connections support it.
+	Schema string
+
+	// Tables are the names of the tables to dump, or path.Match patterns
+	// such as wp_*. Every table is dumped if it's empty.
+	Tables []string
+
+	// ExcludeTables are names or patterns of tables to leave out.
+	ExcludeTables []string
+
+	// Progress is called after each table is dumped. The PHP querier
+	// dumps remotely and doesn't report progress.
+	Progress func(DumpProgress)
+}
+
+type DumpProgress struct {
+	Table string
+
+	// Rows is the number of rows dumped from the table.
+	Rows int
+
+	// Done is the number of tables dumped so far, out of Total.
+	Done  int
+	Total int
+}
+
+func (o DumpOptions) filtered() bool {
+	return len(o.Tables) > 0 || len(o.ExcludeTables) > 0
+}
+
+// includes reports whether a table is selected by the options.
+func (o DumpOptions) includes(table string) (bool, error) {
+	if len(o.Tables) > 0 {
+		ok, err := matchTable(table, o.Tables)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	ok, err := matchTable(table, o.ExcludeTables)
+	return !ok, err
+}
+
+// filter returns the tables selected by the options.
+func (o DumpOptions) filter(tables []string) ([]string, error) {
+	var filtered []string
+	for _, table := range tables {
+		ok, err := o.includes(table)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, table)
+		}
+	}
+	return filtered, nil
 }
 
-func (e dumpError) Error() string {
-	return fmt.Sprintf("%v; %s", e.err, string(e.output))
+func (o DumpOptions) progress(p DumpProgress) {
+	if o.Progress != nil {
+		o.Progress(p)
+	}
+}
+
+func matchTable(table string, patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := path.Match(pattern, table)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
-func mysqldump(ctx context.Context, cfg Config, w io.Writer) error {
-	host, port, err := net.SplitHostPort(cfg.Addr)
+// mysqlMaxInsert is the size at which an extended INSERT statement is
+// ended and a new one begins, like the net_buffer_length of mysqldump.
+const mysqlMaxInsert = 1 << 20
+
+// mysqldump writes a dump of a MySQL database like mysqldump --single-transaction
+// does: every table is read from the same consistent snapshot, without locking.
+func mysqldump(ctx context.Context, db *sql.DB, database string, w io.Writer, opt DumpOptions) error {
+	if opt.Schema != "" {
+		database = opt.Schema
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The connection goes back to the pool, so the session variables
+	// changed below are restored once done.
+	_, err = conn.ExecContext(ctx, "SET @SPLACE_CHARACTER_SET_CLIENT = @@CHARACTER_SET_CLIENT, "+
+		"@SPLACE_CHARACTER_SET_RESULTS = @@CHARACTER_SET_RESULTS, "+
+		"@SPLACE_COLLATION_CONNECTION = @@COLLATION_CONNECTION, @SPLACE_TIME_ZONE = @@TIME_ZONE")
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SET CHARACTER_SET_CLIENT = @SPLACE_CHARACTER_SET_CLIENT, "+
+		"CHARACTER_SET_RESULTS = @SPLACE_CHARACTER_SET_RESULTS, "+
+		"COLLATION_CONNECTION = @SPLACE_COLLATION_CONNECTION, TIME_ZONE = @SPLACE_TIME_ZONE")
+
+	// Timestamps are dumped in UTC, so they restore the same
+	// regardless of the time zone of the server restoring them.
+	// The isolation level applies only to the next transaction.
+	for _, query := range []string{
+		"SET NAMES utf8mb4",
+		"SET SESSION time_zone = '+00:00'",
+		"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION /*!40100 WITH CONSISTENT SNAPSHOT */",
+	} {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	tables, err := mysqlTables(ctx, conn, database)
 	if err != nil {
 		return err
 	}
-	cmd := exec.CommandContext(
-		ctx,
-		filepath.Join("data", "mysqldump"),
-		"-h", host,
-		"--port", port,
-		"-u", cfg.User,
-		"--password="+cfg.Pwd,
-		"--column-statistics=0",
-		cfg.Database)
-	var errBuff bytes.Buffer
-	cmd.Stderr = &errBuff
-	cmd.Stdout = w
-	err = cmd.Run()
-	if err != nil {
-		return &dumpError{
-			err:    err,
-			output: errBuff.Bytes(),
-		}
-	}
-	return nil
+	tables, err = opt.filter(tables)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "-- splace dump of %s\n\n", mysqlQuoteName(database))
+	// Session variables are restored at the end, like mysqldump does,
+	// since the connection restoring the dump may be reused.
+	bw.WriteString("/*!40101 SET NAMES utf8mb4 */;\n" +
+		"SET @OLD_TIME_ZONE = @@TIME_ZONE, TIME_ZONE = '+00:00';\n" +
+		"SET @OLD_FOREIGN_KEY_CHECKS = @@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS = 0;\n" +
+		"SET @OLD_UNIQUE_CHECKS = @@UNIQUE_CHECKS, UNIQUE_CHECKS = 0;\n" +
+		"SET @OLD_SQL_MODE = @@SQL_MODE, SQL_MODE = 'NO_AUTO_VALUE_ON_ZERO';\n\n")
+	if opt.Schema != "" {
+		fmt.Fprintf(bw, "USE %s;\n\n", mysqlQuoteName(database))
+	}
+
+	for i, table := range tables {
+		n, err := mysqldumpTable(ctx, conn, bw, database, table)
+		if err != nil {
+			return err
+		}
+		// Flushing between tables keeps the dump streaming.
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		opt.progress(DumpProgress{
+			Table: table,
+			Rows:  n,
+			Done:  i + 1,
+			Total: len(tables),
+		})
+	}
+
+	bw.WriteString("SET TIME_ZONE = @OLD_TIME_ZONE;\n" +
+		"SET FOREIGN_KEY_CHECKS = @OLD_FOREIGN_KEY_CHECKS;\n" +
+		"SET UNIQUE_CHECKS = @OLD_UNIQUE_CHECKS;\n" +
+		"SET SQL_MODE = @OLD_SQL_MODE;\n")
+	return bw.Flush()
+}
+
+func mysqlTables(ctx context.Context, conn *sql.Conn, database string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES `+
+		`WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME`, database)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+type mysqlColumn struct {
+	name     string
+	dataType string
+}
+
+// mysqlColumns returns the columns of a table that can be inserted into,
+// leaving out generated columns.
+func mysqlColumns(ctx context.Context, conn *sql.Conn, database, table string) ([]mysqlColumn, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT COLUMN_NAME, DATA_TYPE, EXTRA FROM INFORMATION_SCHEMA.COLUMNS `+
+		`WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []mysqlColumn
+	for rows.Next() {
+		var col mysqlColumn
+		var extra string
+		if err := rows.Scan(&col.name, &col.dataType, &extra); err != nil {
+			return nil, err
+		}
+		// DEFAULT_GENERATED marks expression defaults rather than generated columns.
+		extra = strings.ToUpper(extra)
+		if strings.Contains(extra, "VIRTUAL") || strings.Contains(extra, "STORED") ||
+			strings.Contains(extra, "PERSISTENT") {
+			continue
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// mysqldumpTable writes the structure and rows of a table of the database,
+// and returns the number of rows written. Tables are read by their qualified
+// name and written by their name, so the dump restores to any database.
+func mysqldumpTable(ctx context.Context, conn *sql.Conn, w *bufio.Writer, database, table string) (int, error) {
+	quotedTable := mysqlQuoteName(table)
+	qualifiedTable := mysqlQuoteName(database) + "." + quotedTable
+
+	var name, create string
+	err := conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+qualifiedTable).Scan(&name, &create)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(w, "--\n-- Table structure for table %s\n--\n\n", quotedTable)
+	fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\n%s;\n\n", quotedTable, create)
+
+	columns, err := mysqlColumns(ctx, conn, database, table)
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, nil
+	}
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = mysqlQuoteName(col.name)
+	}
+	columnList := strings.Join(names, ", ")
+
+	rows, err := conn.QueryContext(ctx, "SELECT "+columnList+" FROM "+qualifiedTable)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	values := make([]sql.RawBytes, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	insert := "INSERT INTO " + quotedTable + " (" + columnList + ") VALUES\n"
+	var stmt strings.Builder
+	n := 0
+	flush := func() {
+		if stmt.Len() > 0 {
+			w.WriteString(insert)
+			w.WriteString(stmt.String())
+			w.WriteString(";\n")
+			stmt.Reset()
+		}
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		if n == 0 {
+			fmt.Fprintf(w, "--\n-- Dumping data for table %s\n--\n\n", quotedTable)
+			fmt.Fprintf(w, "/*!40000 ALTER TABLE %s DISABLE KEYS */;\n", quotedTable)
+		}
+		if stmt.Len() > 0 {
+			stmt.WriteString(",\n")
+		}
+		stmt.WriteByte('(')
+		for i, v := range values {
+			if i > 0 {
+				stmt.WriteByte(',')
+			}
+			stmt.WriteString(mysqlLiteral(v, columns[i].dataType))
+		}
+		stmt.WriteByte(')')
+		n++
+		if stmt.Len() >= mysqlMaxInsert {
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	flush()
+	if n > 0 {
+		fmt.Fprintf(w, "/*!40000 ALTER TABLE %s ENABLE KEYS */;\n\n", quotedTable)
+	}
+	return n, nil
+}
+
+// mysqlLiteral formats a value of a column as an SQL literal. Binary values are
+// hex-encoded, so the dump stays valid text whatever they hold.
+func mysqlLiteral(v sql.RawBytes, dataType string) string {
+	if v == nil {
+		return "NULL"
+	}
+	switch strings.ToLower(dataType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint",
+		"decimal", "numeric", "float", "double", "real":
+		return string(v)
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob",
+		"bit", "geometry", "point", "linestring", "polygon", "multipoint",
+		"multilinestring", "multipolygon", "geometrycollection":
+		if len(v) == 0 {
+			return "''"
+		}
+		return "0x" + hex.EncodeToString(v)
+	}
+	return mysqlQuote(v)
+}
+
+// mysqlQuote quotes a string, escaping the same characters as mysql_real_escape_string.
+func mysqlQuote(v []byte) string {
+	var b strings.Builder
+	b.Grow(len(v) + 2)
+	b.WriteByte('\'')
+	for _, c := range v {
+		switch c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '"':
+			b.WriteString(`\"`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+func mysqlQuoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
 }
@@ -0,0 +1,55 @@
+package querier
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestDumpOptionsFilter(t *testing.T) {
+	tables := []string{"wp_posts", "wp_options", "users", "logs"}
+	tests := []struct {
+		opt      DumpOptions
+		expected []string
+	}{
+		{DumpOptions{}, tables},
+		{DumpOptions{Tables: []string{"wp_*"}}, []string{"wp_posts", "wp_options"}},
+		{DumpOptions{ExcludeTables: []string{"wp_*", "logs"}}, []string{"users"}},
+		{DumpOptions{Tables: []string{"wp_*", "users"}, ExcludeTables: []string{"wp_options"}}, []string{"wp_posts", "users"}},
+	}
+	for _, test := range tests {
+		filtered, err := test.opt.filter(tables)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(filtered, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.opt, test.expected, filtered)
+		}
+	}
+	if _, err := (DumpOptions{Tables: []string{"wp_["}}).filter(tables); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestMySQLLiteral(t *testing.T) {
+	tests := []struct {
+		value    sql.RawBytes
+		dataType string
+		expected string
+	}{
+		{nil, "varchar", "NULL"},
+		{sql.RawBytes("42"), "int", "42"},
+		{sql.RawBytes("-1.5"), "DECIMAL", "-1.5"},
+		{sql.RawBytes("it's \"here\"\n\\"), "text", `'it\'s \"here\"\n\\'`},
+		{sql.RawBytes("a\x00b\x1a\r"), "varchar", `'a\0b\Z\r'`},
+		{sql.RawBytes{0x00, 0xff, '\''}, "blob", "0x00ff27"},
+		{sql.RawBytes{}, "varbinary", "''"},
+		{sql.RawBytes{}, "varchar", "''"},
+		{sql.RawBytes("2020-01-02 03:04:05"), "datetime", "'2020-01-02 03:04:05'"},
+	}
+	for _, test := range tests {
+		if literal := mysqlLiteral(test.value, test.dataType); literal != test.expected {
+			t.Errorf("%q (%s): expected %s, got %s", test.value, test.dataType, test.expected, literal)
+		}
+	}
+}
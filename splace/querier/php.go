@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
 type cmdArgs map[string]interface{}
@@ -81,8 +82,27 @@ func (p *PHP) Query(ctx context.Context, query string, args ...interface{}) (Row
 	return newPHPRows(resp.Body)
 }
 
-func (p *PHP) Dump(ctx context.Context, w io.Writer) error {
-	resp, err := p.cmd("dump", nil)
+func (p *PHP) Dump(ctx context.Context, w io.Writer, opt DumpOptions) error {
+	if opt.Schema != "" {
+		return errUnsupportedSchema
+	}
+	var args cmdArgs
+	if opt.filtered() {
+		// The proxy takes the exact names of the tables to include.
+		tables, err := p.tables(ctx)
+		if err != nil {
+			return err
+		}
+		tables, err = opt.filter(tables)
+		if err != nil {
+			return err
+		}
+		if len(tables) == 0 {
+			return errNoTablesToDump
+		}
+		args = cmdArgs{"Tables": tables}
+	}
+	resp, err := p.cmd("dump", args)
 	if err != nil {
 		return err
 	}
@@ -91,6 +111,91 @@ func (p *PHP) Dump(ctx context.Context, w io.Writer) error {
 	return err
 }
 
+// restoreChunkSize is the size of the statements sent with each restore command,
+// small enough for the proxy to execute them within max_execution_time.
+const restoreChunkSize = 1 << 20
+
+// Restore sends the statements of a dump to the proxy in chunks. Each chunk is
+// executed on a new connection, so the SET and USE statements of the previous
+// chunks are executed again before it.
+func (p *PHP) Restore(ctx context.Context, r io.Reader) error {
+	r, err := Decompress(r)
+	if err != nil {
+		return err
+	}
+	scanner := NewStatementScanner(r, p.cfg.Engine)
+
+	// session holds the session statements of the chunks sent so far,
+	// and chunkSession those of the chunk being built.
+	var session, chunkSession, chunk []string
+	size := 0
+	send := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		resp, err := p.cmd("restore", cmdArgs{
+			"Session":    session,
+			"Statements": chunk,
+		})
+		if err != nil {
+			return err
+		}
+		session = append(session, chunkSession...)
+		chunk, chunkSession, size = nil, nil, 0
+		return resp.Body.Close()
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		stmt, err := scanner.Next()
+		if err == io.EOF {
+			return send()
+		}
+		if err != nil {
+			return err
+		}
+		chunk = append(chunk, stmt)
+		size += len(stmt)
+		if isSessionStatement(stmt) {
+			chunkSession = append(chunkSession, stmt)
+		}
+		if size >= restoreChunkSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// isSessionStatement reports whether a statement changes the state of
+// the connection, such as SET NAMES, including inside /*!40101 ... */.
+func isSessionStatement(stmt string) bool {
+	if strings.HasPrefix(stmt, "/*!") {
+		stmt = strings.TrimLeft(stmt[3:], "0123456789")
+	}
+	stmt = strings.ToUpper(strings.TrimSpace(stmt))
+	return strings.HasPrefix(stmt, "SET ") || strings.HasPrefix(stmt, "USE ")
+}
+
+func (p *PHP) tables(ctx context.Context) ([]string, error) {
+	rows, err := p.Query(ctx, `SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES `+
+		`WHERE TABLE_SCHEMA = DATABASE() ORDER BY TABLE_NAME`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, row[0])
+	}
+	return tables, rows.Err()
+}
+
 func (p *PHP) Config() Config {
 	return p.cfg
 }
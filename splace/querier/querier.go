@@ -8,6 +8,7 @@ import (
 
 var (
 	ErrUnsupportedEngine = errors.New("database engine is not supported")
+	ErrInvalidDSN        = errors.New("invalid data source name")
 )
 
 type Engine string
@@ -17,6 +18,7 @@ const (
 	PostgreSQL Engine = "postgres"
 	SQLServer  Engine = "sqlserver"
 	Oracle     Engine = "oracle"
+	SQLite     Engine = "sqlite3"
 )
 
 type Querier interface {
@@ -29,7 +31,12 @@ type Querier interface {
 	Exec(ctx context.Context, query string, args ...interface{}) (Result, error)
 	Query(ctx context.Context, query string, args ...interface{}) (Rows, error)
 
-	Dump(ctx context.Context, w io.Writer) error
+	// Dump writes an SQL dump of the database, which restores it when executed.
+	Dump(ctx context.Context, w io.Writer, opt DumpOptions) error
+
+	// Restore executes the statements of an SQL dump, plain or gzipped,
+	// such as one written by Dump.
+	Restore(ctx context.Context, r io.Reader) error
 
 	Close() error
 }
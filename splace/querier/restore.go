@@ -0,0 +1,259 @@
+package querier
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+)
+
+var errBadDelimiter = errors.New("DELIMITER without a delimiter")
+
+// Decompress returns a reader of the uncompressed content of r, which may be gzipped.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// StatementScanner splits an SQL dump into statements, leaving out comments
+// between statements other than MySQL's executable /*! ... */ comments.
+// Comments within statements, such as in the bodies of routines, are kept.
+// Statements end with a semicolon, or the delimiter set by a DELIMITER command
+// of the mysql client, which dumps use around the bodies of triggers and routines.
+type StatementScanner struct {
+	r         *bufio.Reader
+	delimiter string
+	buf       bytes.Buffer
+
+	// raw is set to keep the text between statements and the delimiters.
+	raw bool
+
+	// backslashEscapes is set for MySQL, where a backslash escapes
+	// the next character of a quoted string.
+	backslashEscapes bool
+}
+
+func NewStatementScanner(r io.Reader, engine Engine) *StatementScanner {
+	return &StatementScanner{
+		r:                bufio.NewReaderSize(r, 1<<16),
+		delimiter:        ";",
+		backslashEscapes: engine == MySQL,
+	}
+}
+
+// NewRawStatementScanner returns a scanner of the raw statements of a dump,
+// each including the comments and whitespace before it and its delimiter,
+// with DELIMITER commands as statements of their own, so the dump can be
+// rewritten a statement at a time by writing out every statement.
+func NewRawStatementScanner(r io.Reader, engine Engine) *StatementScanner {
+	s := NewStatementScanner(r, engine)
+	s.raw = true
+	return s
+}
+
+// Next returns the next statement without its delimiter, or io.EOF at the end.
+func (s *StatementScanner) Next() (string, error) {
+	stmt, err := s.NextBytes()
+	return string(stmt), err
+}
+
+// NextBytes is like Next, but returns bytes that are only valid
+// until the next call.
+func (s *StatementScanner) NextBytes() ([]byte, error) {
+	s.buf.Reset()
+	// blank is set until the statement begins, after comments and whitespace.
+	blank := true
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			if s.raw && s.buf.Len() > 0 || !blank {
+				return s.statement(), nil
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == s.delimiter[0] && s.peekString(s.delimiter[1:]) {
+			if !s.raw {
+				s.r.Discard(len(s.delimiter) - 1)
+				if blank {
+					s.buf.Reset()
+					continue
+				}
+				return s.statement(), nil
+			}
+			s.buf.WriteByte(c)
+			s.copyN(len(s.delimiter) - 1)
+			return s.buf.Bytes(), nil
+		}
+		switch c {
+		case 'D', 'd':
+			// DELIMITER is a command of its own line, between statements.
+			if s.backslashEscapes && blank && s.peekFold("ELIMITER ") {
+				start := s.buf.Len()
+				s.buf.WriteByte(c)
+				if err := s.line(); err != nil {
+					return nil, err
+				}
+				delimiter := strings.TrimSpace(string(s.buf.Bytes()[start+len("DELIMITER "):]))
+				if delimiter == "" {
+					return nil, errBadDelimiter
+				}
+				s.delimiter = delimiter
+				if s.raw {
+					return s.buf.Bytes(), nil
+				}
+				s.buf.Reset()
+				continue
+			}
+		case '\'', '"', '`':
+			blank = false
+			s.buf.WriteByte(c)
+			if err := s.quoted(c); err != nil {
+				return nil, err
+			}
+			continue
+		case '#':
+			if s.backslashEscapes {
+				if err := s.comment(c, blank, s.line); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		case '-':
+			if next, _ := s.r.Peek(2); len(next) > 0 && next[0] == '-' &&
+				(len(next) == 1 || next[1] <= ' ') {
+				if err := s.comment(c, blank, s.line); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		case '/':
+			if next, _ := s.r.Peek(2); len(next) > 0 && next[0] == '*' &&
+				(len(next) == 1 || next[1] != '!') {
+				if err := s.comment(c, blank, s.blockComment); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		s.buf.WriteByte(c)
+		if c > ' ' {
+			blank = false
+		}
+	}
+}
+
+// statement returns the statement read so far, trimmed unless raw.
+func (s *StatementScanner) statement() []byte {
+	if s.raw {
+		return s.buf.Bytes()
+	}
+	return bytes.TrimSpace(s.buf.Bytes())
+}
+
+// quoted copies a quoted string or name up to its closing quote.
+func (s *StatementScanner) quoted(quote byte) error {
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		s.buf.WriteByte(c)
+		if c == '\\' && quote != '`' && s.backslashEscapes {
+			c, err := s.r.ReadByte()
+			if err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			s.buf.WriteByte(c)
+			continue
+		}
+		if c == quote {
+			// A doubled quote stands for the quote itself.
+			if s.peekString(string(quote)) {
+				s.copyN(1)
+				continue
+			}
+			return nil
+		}
+	}
+}
+
+// peekString reports whether the next bytes are str.
+func (s *StatementScanner) peekString(str string) bool {
+	next, _ := s.r.Peek(len(str))
+	return string(next) == str
+}
+
+// peekFold reports whether the next bytes are str, ignoring case.
+func (s *StatementScanner) peekFold(str string) bool {
+	next, _ := s.r.Peek(len(str))
+	return strings.EqualFold(string(next), str)
+}
+
+// copyN copies the next n bytes, which have been peeked.
+func (s *StatementScanner) copyN(n int) {
+	for i := 0; i < n; i++ {
+		c, _ := s.r.ReadByte()
+		s.buf.WriteByte(c)
+	}
+}
+
+// comment copies the rest of a comment beginning with c using read. Unless raw,
+// a comment before the statement rather than within it is left out.
+func (s *StatementScanner) comment(c byte, blank bool, read func() error) error {
+	start := s.buf.Len()
+	s.buf.WriteByte(c)
+	if err := read(); err != nil {
+		return err
+	}
+	if blank && !s.raw {
+		s.buf.Truncate(start)
+		s.buf.WriteByte(' ')
+	}
+	return nil
+}
+
+// line copies the rest of a line, including its line break.
+func (s *StatementScanner) line() error {
+	line, err := s.r.ReadBytes('\n')
+	s.buf.Write(line)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// blockComment copies the rest of a /* ... */ comment.
+func (s *StatementScanner) blockComment() error {
+	// The asterisk that opens the comment doesn't close it.
+	s.copyN(1)
+	prev := byte(0)
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		s.buf.WriteByte(c)
+		if prev == '*' && c == '/' {
+			return nil
+		}
+		prev = c
+	}
+}
@@ -0,0 +1,134 @@
+package querier
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatementScanner(t *testing.T) {
+	tests := []struct {
+		engine   Engine
+		dump     string
+		expected []string
+	}{
+		{
+			MySQL,
+			"-- splace dump\n/*!40101 SET NAMES utf8mb4 */;\nSET @A = 1; # comment\n" +
+				"INSERT INTO `t;` VALUES ('a;b','it\\'s','c''d',\"e;\\\"f\");\n/* block; comment */ SELECT 1",
+			[]string{
+				"/*!40101 SET NAMES utf8mb4 */",
+				"SET @A = 1",
+				"INSERT INTO `t;` VALUES ('a;b','it\\'s','c''d',\"e;\\\"f\")",
+				"SELECT 1",
+			},
+		},
+		{
+			MySQL,
+			"CREATE PROCEDURE p() BEGIN -- a; b\nSELECT /* c; */ 1; END;",
+			[]string{"CREATE PROCEDURE p() BEGIN -- a; b\nSELECT /* c; */ 1", "END"},
+		},
+		{
+			MySQL,
+			"SELECT 1--1;\nSELECT '--';;",
+			[]string{"SELECT 1--1", "SELECT '--'"},
+		},
+		{
+			MySQL,
+			"DELIMITER ;;\n/*!50003 CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.b = ';'; SET NEW.c = 1; END */;;\n" +
+				"delimiter ;\nSELECT 1;",
+			[]string{
+				"/*!50003 CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.b = ';'; SET NEW.c = 1; END */",
+				"SELECT 1",
+			},
+		},
+		{
+			SQLite,
+			"BEGIN TRANSACTION;\nINSERT INTO \"a\" VALUES(1,'back\\');\nCOMMIT;\n",
+			[]string{"BEGIN TRANSACTION", "INSERT INTO \"a\" VALUES(1,'back\\')", "COMMIT"},
+		},
+	}
+	for _, test := range tests {
+		scanner := NewStatementScanner(strings.NewReader(test.dump), test.engine)
+		var statements []string
+		for {
+			stmt, err := scanner.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			statements = append(statements, stmt)
+		}
+		if !reflect.DeepEqual(statements, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.dump, test.expected, statements)
+		}
+	}
+
+	scanner := NewStatementScanner(strings.NewReader("SELECT 'unterminated;"), MySQL)
+	if _, err := scanner.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestRawStatementScanner(t *testing.T) {
+	const dump = "-- splace dump\n/*!40101 SET NAMES utf8mb4 */;\n\nDELIMITER ;;\n" +
+		"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET NEW.b = ';';;\nDELIMITER ;\n# end\n"
+	expected := []string{
+		"-- splace dump\n/*!40101 SET NAMES utf8mb4 */;",
+		"\n\nDELIMITER ;;\n",
+		"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET NEW.b = ';';;",
+		"\nDELIMITER ;\n",
+		"# end\n",
+	}
+	scanner := NewRawStatementScanner(strings.NewReader(dump), MySQL)
+	var statements []string
+	for {
+		stmt, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		statements = append(statements, stmt)
+	}
+	if !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected %q, got %q", expected, statements)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	const dump = "SELECT 1;\n"
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write([]byte(dump))
+	w.Close()
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte(dump), dump},
+		{gzipped.Bytes(), dump},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		r, err := Decompress(bytes.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, b)
+		}
+	}
+}
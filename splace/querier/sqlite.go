@@ -0,0 +1,307 @@
+package querier
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is the SQLite driver extended with the REGEXP operator
+// and a regexp_replace function, which SQLite lacks out of the box.
+const sqliteDriver = "splace_sqlite3"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("regexp", sqliteRegexp, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("regexp_replace", sqliteRegexpReplace, true)
+		},
+	})
+}
+
+// sqliteRegexps caches compiled expressions, since SQLite calls
+// the regexp functions once for every row.
+var sqliteRegexps sync.Map
+
+func sqliteCompile(expr string) (*regexp.Regexp, error) {
+	if re, ok := sqliteRegexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	sqliteRegexps.Store(expr, re)
+	return re, nil
+}
+
+func sqliteValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func sqliteRegexp(expr string, value interface{}) (bool, error) {
+	s, ok := sqliteValue(value)
+	if !ok {
+		return false, nil
+	}
+	re, err := sqliteCompile(expr)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+func sqliteRegexpReplace(value interface{}, expr, repl string) (string, error) {
+	s, _ := sqliteValue(value)
+	re, err := sqliteCompile(expr)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// sqlitedump writes a portable SQL dump of the database,
+// similar to the .dump command of the sqlite3 shell.
+func sqlitedump(ctx context.Context, db *sql.DB, w io.Writer, opt DumpOptions) error {
+	// A read transaction holds a shared lock, so the dump is consistent.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := io.WriteString(w, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n"); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT name, sql FROM sqlite_master `+
+		`WHERE type = 'table' AND sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY rowid`)
+	if err != nil {
+		return err
+	}
+	var tables, schemas []string
+	for rows.Next() {
+		var table, schema string
+		if err := rows.Scan(&table, &schema); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+		schemas = append(schemas, schema)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	included := map[string]bool{}
+	var total int
+	for _, table := range tables {
+		ok, err := opt.includes(table)
+		if err != nil {
+			return err
+		}
+		if ok {
+			included[table] = true
+			total++
+		}
+	}
+
+	done := 0
+	for i, table := range tables {
+		if !included[table] {
+			continue
+		}
+		// Tables are replaced, so the dump can restore a backup
+		// of some tables into a database that still has them.
+		_, err := fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\n%s;\n", sqliteQuoteName(table), schemas[i])
+		if err != nil {
+			return err
+		}
+		n, err := sqlitedumpRows(ctx, tx, w, table, nil)
+		if err != nil {
+			return err
+		}
+		done++
+		opt.progress(DumpProgress{
+			Table: table,
+			Rows:  n,
+			Done:  done,
+			Total: total,
+		})
+	}
+
+	// AUTOINCREMENT counters live in sqlite_sequence, which is created
+	// along with the first AUTOINCREMENT table.
+	var hasSequence bool
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM sqlite_master `+
+		`WHERE type = 'table' AND name = 'sqlite_sequence'`).Scan(&hasSequence)
+	if err != nil {
+		return err
+	}
+	if hasSequence {
+		// sqlite_sequence is missing from the restored database unless a dumped
+		// table uses AUTOINCREMENT, so nothing is written without counters.
+		// Inserting the rows of a table already sets its counter, which is
+		// replaced, while a dump of some tables keeps the counters of the others.
+		var seq bytes.Buffer
+		n, err := sqlitedumpRows(ctx, tx, &seq, "sqlite_sequence", func(row []string) bool {
+			// Rows of sqlite_sequence are (name, seq), with name quoted.
+			return !opt.filtered() || included[sqliteUnquote(row[0])]
+		})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			clear := "DELETE FROM sqlite_sequence;\n"
+			if opt.filtered() {
+				names := make([]string, 0, len(included))
+				for table := range included {
+					names = append(names, sqliteQuote(table))
+				}
+				sort.Strings(names)
+				clear = "DELETE FROM sqlite_sequence WHERE name IN (" + strings.Join(names, ",") + ");\n"
+			}
+			if _, err := io.WriteString(w, clear); err != nil {
+				return err
+			}
+			if _, err := seq.WriteTo(w); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Indexes, triggers and views come last so they
+	// don't slow down or interfere with the inserts. Indexes and
+	// triggers are dumped along with their tables.
+	rows, err = tx.QueryContext(ctx, `SELECT type, tbl_name, sql FROM sqlite_master `+
+		`WHERE type IN ('index', 'trigger', 'view') AND sql IS NOT NULL ORDER BY rowid`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var typ, table, schema string
+		if err := rows.Scan(&typ, &table, &schema); err != nil {
+			return err
+		}
+		if typ == "view" {
+			ok, err := opt.includes(table)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "DROP VIEW IF EXISTS %s;\n", sqliteQuoteName(table)); err != nil {
+				return err
+			}
+		} else if opt.filtered() && !included[table] {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s;\n", schema); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "COMMIT;\n")
+	return err
+}
+
+// sqlitedumpRows writes the rows of a table accepted by include, or every
+// row if it's nil, and returns the number of rows written.
+func sqlitedumpRows(ctx context.Context, tx *sql.Tx, w io.Writer, table string, include func(row []string) bool) (int, error) {
+	quotedTable := sqliteQuoteName(table)
+
+	columns, err := sqliteColumns(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+	// SQLite's quote() formats any value as an SQL literal,
+	// including blobs as X'...' and NULL.
+	exprs := make([]string, len(columns))
+	for i, col := range columns {
+		exprs[i] = "quote(" + sqliteQuoteName(col) + ")"
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+strings.Join(exprs, ", ")+" FROM "+quotedTable)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	values := make([]string, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		if include != nil && !include(values) {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "INSERT INTO %s VALUES(%s);\n", quotedTable, strings.Join(values, ","))
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func sqliteQuoteName(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// sqliteQuote quotes a text value like quote() does.
+func sqliteQuote(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// sqliteUnquote reverses quote() of a text value.
+func sqliteUnquote(literal string) string {
+	if len(literal) < 2 || literal[0] != '\'' {
+		return literal
+	}
+	return strings.Replace(literal[1:len(literal)-1], "''", "'", -1)
+}
+
+func sqliteColumns(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
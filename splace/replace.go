@@ -2,8 +2,15 @@ package splace
 
 import (
 	"context"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/zippoxer/splace/splace/querier"
 )
 
@@ -12,33 +19,151 @@ type ReplaceOptions struct {
 	Replace string
 	Mode    Mode
 
+	// Pairs, if set, are replaced instead of Search with Replace, each in its
+	// own mode. Pairs are applied to every value in order, so that a later pair
+	// sees the replacements of those before it, and rows matching any of them
+	// are updated by a single query with the replacements nested in that order.
+	// Pairs alternating between Equals and other modes too often to nest are
+	// replaced as with ClientSide.
+	Pairs []Pair
+
 	// Tables is a map of selected table names and column names.
 	Tables TableMap
 
 	// Limit sets the maximum amount of rows updated with each query.
+	// Tables are walked by primary key, or a unique key, in ranges of Limit rows.
+	// Tables without such a key are updated with a single query.
 	// A lower limit would provide frequent progress updates,
 	// while with a higher limit the operation would complete faster.
 	// Set to 0 for no limit.
 	Limit int
+
+	// Serialized makes the replacement inside PHP serialized values, such as
+	// WordPress options, and corrects the lengths of the strings it changes.
+	// Values that aren't serialized are replaced as usual.
+	//
+	// Matching rows are fetched and replaced in Go, then written back one by one
+	// by primary key.
+	Serialized bool
+
+	// ClientSide fetches matching rows and makes the replacement in Go, then writes
+	// back changed rows one by one by primary key, as long as they haven't changed
+	// in the meantime. Regexp replacements use $1 for capture groups.
+	//
+	// Regexp mode falls back to it when the database lacks REGEXP_REPLACE,
+	// such as MySQL 5.7 and older MariaDB.
+	ClientSide bool
+
+	// Journal, if set, records the key and old value of every cell the replacement
+	// changes, so that it can be undone with Splace.Rollback. Like ClientSide,
+	// the replacement is made in Go. Tables without a key are skipped.
+	Journal *JournalWriter `json:"-"`
+
+	// Backup, if set, receives an SQL dump of the tables in Tables, written with
+	// the querier's Dump before anything is replaced. Nothing is replaced if
+	// the dump fails. Restore it with the querier's Restore.
+	Backup io.Writer `json:"-"`
+
+	// Mapping, if set, replaces each of its searches with its replacement in
+	// a single pass over every value, in place of Search, Replace and Pairs.
+	// Every row with a value in the selected columns is fetched and replaced
+	// in Go, and changed rows are written back one by one by primary key,
+	// as with ClientSide. Objects are all candidates, as are rows.
+	Mapping *Mapping `json:"-"`
+
+	// Objects also replaces in the definitions of the views, stored routines,
+	// triggers and events of the schemas of Tables, after the tables, recreating
+	// each changed object from its SHOW CREATE statement with the same definer
+	// and SQL mode. Views, and other objects in MariaDB, are replaced with
+	// CREATE OR REPLACE. In MySQL other objects are dropped and created again,
+	// and recreated as they were if creating them fails, but the grants on
+	// routines are lost. Backup also receives the statements recreating them.
+	// Only MySQL supports it, and Preview leaves objects out.
+	Objects bool
 }
 
 type ReplaceResult struct {
 	Table string
-	SQL   string
+	// Schema is the schema of a table qualified as schema.table, or empty.
+	Schema string
+	// Object is the kind of the object, such as ObjectView, for results of
+	// ReplaceOptions.Objects, whose SQL is the new CREATE statement and
+	// whose AffectedRows transmits 1 once the object is recreated.
+	// It's empty for tables.
+	Object string
+
+	SQL string
+
+	// Skipped lists the selected columns of the table that aren't replaced in,
+	// since they can't be updated, and why. AffectedRows is closed right away
+	// when every column is skipped.
+	Skipped []SkippedColumn
+
+	// Conflicts lists the key values of the rows of Splace.ReplaceInRows that
+	// have changed since they were seen, or are gone, and were left as they are.
+	Conflicts [][]string
 
 	// AffectedRows transmits the number of updated rows as soon as
-	// each query completes. Expect only one transmission if ReplaceOptions.Limit is set to zero.
+	// each query that updated any rows completes. Expect only one transmission if ReplaceOptions.Limit is set to zero.
 	// AffectedRows is closed when we're done replacing in this table.
 	AffectedRows <-chan int
 
 	Start time.Time
 }
 
+// Pair is a search and its replacement, made in its own mode.
+type Pair struct {
+	Search  string
+	Replace string
+	Mode    Mode
+}
+
+// pairs returns Pairs, or Search and Replace as a single pair.
+func (opt ReplaceOptions) pairs() []Pair {
+	if len(opt.Pairs) > 0 {
+		return opt.Pairs
+	}
+	return []Pair{{Search: opt.Search, Replace: opt.Replace, Mode: opt.Mode}}
+}
+
+// supports reports whether the dialect supports the modes of every pair.
+func (opt ReplaceOptions) supports(d dialect, update bool) bool {
+	if opt.Mapping != nil {
+		// A mapping is matched in Go.
+		return true
+	}
+	for _, p := range opt.pairs() {
+		if !d.supports(p.Mode, update) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasMode reports whether any of the pairs is in the mode.
+func (opt ReplaceOptions) hasMode(mode Mode) bool {
+	for _, p := range opt.pairs() {
+		if p.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// SkippedColumn is a column left out of a replacement, since it can't be updated.
+type SkippedColumn struct {
+	Column string
+	Reason string
+}
+
 type Replacer struct {
 	ctx context.Context
 	db  querier.Querier
 	opt ReplaceOptions
 
+	// backedUp is set once the backup is complete.
+	backedUp bool
+
 	results chan ReplaceResult
 	done    chan error
 }
@@ -60,44 +185,216 @@ func (r *Replacer) start() {
 }
 
 func (r *Replacer) replace() error {
-	qb := &queryBuilder{}
+	qb := newQueryBuilder(r.db.Config().Engine)
+
+	clientSide := r.opt.ClientSide || r.opt.Serialized || r.opt.Journal != nil || r.opt.Mapping != nil ||
+		equalsRuns(r.opt.pairs()) > maxEqualsRuns
+	if !clientSide && r.opt.hasMode(Regexp) && qb.dialect().supports(Regexp, false) {
+		supported, err := r.serverRegexpReplace(qb)
+		if err != nil {
+			return err
+		}
+		clientSide = !supported
+	}
+
+	// replace is set when the replacement is made in Go rather than by the database.
+	var replace func(string) string
+	if clientSide {
+		var err error
+		replace, err = r.opt.valueReplacer()
+		if err != nil {
+			return err
+		}
+	}
+	if !r.opt.supports(qb.dialect(), replace == nil) {
+		return ErrUnsupportedMode
+	}
+	// Objects are found before the backup, so that it holds every object
+	// about to be recreated.
+	var objects []object
+	if r.opt.Objects {
+		pairs := r.opt.pairs()
+		if r.opt.Mapping != nil {
+			pairs = nil
+		}
+		var err error
+		objects, err = findObjects(r.ctx, r.db, r.opt.Tables, pairs)
+		if err != nil {
+			return err
+		}
+	}
+	if r.opt.Backup != nil {
+		if err := r.backup(); err != nil {
+			return err
+		}
+		if err := r.backupObjects(objects); err != nil {
+			return err
+		}
+		r.backedUp = true
+	}
+
 	for table, columns := range r.opt.Tables {
-		var cols []string
-		for _, col := range columns {
-			if isColumnTypeReplacable(col.Type) {
-				cols = append(cols, col.Column)
+		cols, skipped := replacableColumns(qb.dialect(), columns)
+		if len(cols) == 0 {
+			if len(skipped) > 0 {
+				r.skipTable(table, skipped)
 			}
+			continue
+		}
+		var err error
+		if replace != nil {
+			err = r.replaceTableRows(qb, table, cols, skipped, replace)
+		} else {
+			err = r.replaceTable(qb, table, cols, skipped)
+		}
+		if err != nil {
+			return err
 		}
-		if err := r.replaceTable(qb, table, cols); err != nil {
+	}
+	return r.replaceObjects(objects)
+}
+
+// skipTable reports a table none of the selected columns of which can be updated.
+func (r *Replacer) skipTable(table string, skipped []SkippedColumn) {
+	affectedRows := make(chan int)
+	close(affectedRows)
+	r.results <- ReplaceResult{
+		Table:        table,
+		Schema:       tableSchema(table),
+		Skipped:      skipped,
+		AffectedRows: affectedRows,
+		Start:        time.Now(),
+	}
+}
+
+// backup dumps the tables about to be replaced, with a dump for each schema
+// of tables qualified as schema.table.
+func (r *Replacer) backup() error {
+	schemas := map[string][]string{}
+	for table := range r.opt.Tables {
+		schema, name := splitTable(table)
+		schemas[schema] = append(schemas[schema], escapePattern(name))
+	}
+	names := make([]string, 0, len(schemas))
+	for schema := range schemas {
+		names = append(names, schema)
+	}
+	// Unqualified tables come first, before any USE statement.
+	sort.Strings(names)
+	for _, schema := range names {
+		err := r.db.Dump(r.ctx, r.opt.Backup, querier.DumpOptions{
+			Schema: schema,
+			Tables: schemas[schema],
+		})
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *Replacer) replaceTable(qb *queryBuilder, table string, columns []string) error {
-	query := qb.build(queryOptions{
+// escapePattern escapes the characters path.Match treats specially,
+// so the pattern matches only the given name.
+func escapePattern(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		switch c {
+		case '*', '?', '[', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// replaceTable makes the replacement in the database. With a limit, the table is walked
+// by key in ranges of up to Limit rows, so every row is updated exactly once even when
+// the replacement contains the search. Otherwise a single query updates the whole table.
+func (r *Replacer) replaceTable(qb *queryBuilder, table string, columns []string, skipped []SkippedColumn) error {
+	opt := queryOptions{
 		table:   table,
 		columns: columns,
-		mode:    r.opt.Mode,
-		search:  r.opt.Search,
-		limit:   r.opt.Limit,
 		update:  true,
-		replace: r.opt.Replace,
-	})
+		pairs:   r.opt.pairs(),
+	}
+
+	var key []string
+	if r.opt.Limit > 0 {
+		var err error
+		key, err = tableKey(r.ctx, r.db, qb.dialect(), table)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replacing in the key would move rows between ranges, so key columns
+	// are replaced by a single query once the ranges are done.
+	var keyColumns []string
+	if len(key) > 0 {
+		var rest []string
+		for _, col := range columns {
+			if containsString(key, col) {
+				keyColumns = append(keyColumns, col)
+			} else {
+				rest = append(rest, col)
+			}
+		}
+		if len(rest) == 0 {
+			key, keyColumns = nil, nil
+		} else {
+			opt.columns = rest
+		}
+	}
 
 	iterations := make(chan int)
 	defer close(iterations)
 
-	r.results <- ReplaceResult{
-		Table:        table,
-		SQL:          query,
-		AffectedRows: iterations,
-		Start:        time.Now(),
+	var lo []string
+	for first := true; ; first = false {
+		var hi []string
+		if len(key) > 0 {
+			query, args := qb.keyAt(table, key, lo, r.opt.Limit-1)
+			rows, err := fetchRows(r.ctx, r.db, query, args, len(key))
+			if err != nil {
+				return err
+			}
+			if len(rows) > 0 {
+				hi = rows[0].key
+			}
+		}
+		query, args := qb.updateRange(opt, key, lo, hi)
+
+		if first {
+			r.results <- ReplaceResult{
+				Table:        table,
+				Schema:       tableSchema(table),
+				SQL:          query,
+				Skipped:      skipped,
+				AffectedRows: iterations,
+				Start:        time.Now(),
+			}
+		}
+
+		result, err := r.db.Exec(r.ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected > 0 {
+			iterations <- int(rowsAffected)
+		}
+		if hi == nil {
+			break
+		}
+		lo = hi
 	}
 
-	for {
-		result, err := r.db.Exec(r.ctx, query)
+	if len(keyColumns) > 0 {
+		opt.columns = keyColumns
+		result, err := r.db.Exec(r.ctx, qb.build(opt))
 		if err != nil {
 			return err
 		}
@@ -105,16 +402,224 @@ func (r *Replacer) replaceTable(qb *queryBuilder, table string, columns []string
 		if err != nil {
 			return err
 		}
-		if rowsAffected == 0 {
-			return nil
+		if rowsAffected > 0 {
+			iterations <- int(rowsAffected)
+		}
+	}
+	return nil
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// replacableColumns returns the names of the columns that can be replaced in,
+// and the columns that can't along with the reason.
+func replacableColumns(d dialect, columns []ColumnInfo) ([]string, []SkippedColumn) {
+	var (
+		cols    []string
+		skipped []SkippedColumn
+	)
+	for _, col := range columns {
+		var reason string
+		switch {
+		case col.TableType == View:
+			reason = "views are replaced in through their base tables"
+		case col.Generated:
+			reason = "generated columns can't be updated"
+		case !isColumnTypeReplacable(col.Type) || !d.replacable(col.Type):
+			reason = "columns of type " + col.Type + " can't be assigned the replacement"
+		default:
+			cols = append(cols, col.Column)
+			continue
+		}
+		skipped = append(skipped, SkippedColumn{Column: col.Column, Reason: reason})
+	}
+	return cols, skipped
+}
+
+// valueReplacer returns a function making the replacement in a single value,
+// the same way the database would, with the pairs applied in order.
+func (opt ReplaceOptions) valueReplacer() (func(string) string, error) {
+	replace, err := opt.plainReplacer()
+	if err != nil {
+		return nil, err
+	}
+	return opt.serialized(replace), nil
+}
+
+// serialized returns plain, or with Serialized, a function making the
+// replacement of plain within PHP serialized values.
+func (opt ReplaceOptions) serialized(plain func(string) string) func(string) string {
+	if !opt.Serialized {
+		return plain
+	}
+	return func(s string) string {
+		if result, ok := replaceSerialized(s, plain); ok {
+			return result
+		}
+		return plain(s)
+	}
+}
+
+// countHits counts the matches of the mapping, if any, in a value whose
+// replacement has been written, so that values left as they were because
+// of conflicts or failed writes don't count.
+func (opt ReplaceOptions) countHits(s string) {
+	if opt.Mapping != nil {
+		opt.countHitsInto(s, opt.Mapping.hits)
+	}
+}
+
+// countHitsInto counts the matches of the mapping in a value into hits,
+// in the order of its pairs.
+func (opt ReplaceOptions) countHitsInto(s string, hits []int64) {
+	opt.serialized(func(s string) string {
+		return opt.Mapping.apply(s, hits)
+	})(s)
+}
+
+// plainReplacer returns a function making the replacement of the mapping,
+// or of the pairs in order, in a single value.
+func (opt ReplaceOptions) plainReplacer() (func(string) string, error) {
+	if opt.Mapping != nil {
+		return opt.Mapping.replace, nil
+	}
+	var replacers []func(string) string
+	for _, p := range opt.pairs() {
+		replace, err := p.replacer()
+		if err != nil {
+			return nil, err
+		}
+		replacers = append(replacers, replace)
+	}
+	if len(replacers) == 1 {
+		return replacers[0], nil
+	}
+	return func(s string) string {
+		for _, r := range replacers {
+			s = r(s)
+		}
+		return s
+	}, nil
+}
+
+// replacer returns a function making the replacement of the pair in a single value.
+func (p Pair) replacer() (func(string) string, error) {
+	switch p.Mode {
+	case Equals:
+		return func(s string) string {
+			if s == p.Search {
+				return p.Replace
+			}
+			return s
+		}, nil
+	case Contains:
+		return func(s string) string {
+			if p.Search == "" {
+				return s
+			}
+			return strings.Replace(s, p.Search, p.Replace, -1)
+		}, nil
+	case Regexp:
+		re, err := regexp.Compile(p.Search)
+		if err != nil {
+			return nil, err
+		}
+		template := regexpTemplate(p.Replace)
+		return func(s string) string {
+			return re.ReplaceAllString(s, template)
+		}, nil
+	}
+	return nil, ErrUnsupportedMode
+}
+
+// regexpTemplate converts a MySQL REGEXP_REPLACE replacement to Go's syntax.
+// In both $1 stands for the first capture group, but Go would read $1a
+// as a group named "1a" and $a as a group named "a", and backslash
+// escapes don't exist in Go.
+func regexpTemplate(replace string) string {
+	var b strings.Builder
+	for i := 0; i < len(replace); i++ {
+		c := replace[i]
+		switch {
+		case c == '\\' && i+1 < len(replace):
+			i++
+			if replace[i] == '$' {
+				b.WriteString("$$")
+			} else {
+				b.WriteByte(replace[i])
+			}
+		case c == '$' && i+1 < len(replace) && replace[i+1] >= '0' && replace[i+1] <= '9':
+			j := i + 1
+			for j < len(replace) && replace[j] >= '0' && replace[j] <= '9' {
+				j++
+			}
+			b.WriteString("${" + replace[i+1:j] + "}")
+			i = j - 1
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// serverRegexpReplace reports whether the database can make regexp replacements.
+// Errors other than a missing function, such as a lost connection, are returned.
+func (r *Replacer) serverRegexpReplace(qb *queryBuilder) (bool, error) {
+	d := qb.dialect()
+	rows, err := r.db.Query(r.ctx, "SELECT "+d.replace(d.literal("a"), "a", "b", Regexp))
+	if err == nil {
+		for rows.Next() {
 		}
-		iterations <- int(rowsAffected)
-		if r.opt.Limit == 0 {
-			return nil
+		err = rows.Err()
+		rows.Close()
+	}
+	if err != nil {
+		if isUndefinedFunction(err) {
+			return false, nil
 		}
+		return false, err
+	}
+	return true, nil
+}
+
+// isUndefinedFunction reports whether err tells that the database lacks a function,
+// such as REGEXP_REPLACE before MySQL 8.0.
+func isUndefinedFunction(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		// ER_SP_DOES_NOT_EXIST and ER_FUNC_INEXISTENT_NAME_COLLISION.
+		return myErr.Number == 1305 || myErr.Number == 1630
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "42883" // undefined_function
 	}
+	// Errors relayed by the PHP proxy, and those of SQLite, only have a message.
+	// PDO prefixes it with the SQLSTATE and the error number, as in
+	// "SQLSTATE[42000]: Syntax error or access violation: 1305 FUNCTION wp.REGEXP_REPLACE does not exist".
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "no such function") {
+		return true
+	}
+	if i := strings.Index(msg, "function "); i != -1 && strings.Contains(msg[i:], " does not exist") {
+		return true
+	}
+	return undefinedFunctionNumber.MatchString(msg)
 }
 
+// undefinedFunctionNumber matches the error numbers of ER_SP_DOES_NOT_EXIST and
+// ER_FUNC_INEXISTENT_NAME_COLLISION in a message relayed by the PHP proxy.
+var undefinedFunctionNumber = regexp.MustCompile(`: (1305|1630) `)
+
 func (r *Replacer) Results() <-chan ReplaceResult {
 	return r.results
 }
@@ -122,3 +627,10 @@ func (r *Replacer) Results() <-chan ReplaceResult {
 func (r *Replacer) Done() <-chan error {
 	return r.done
 }
+
+// BackedUp reports, once Done transmits, whether ReplaceOptions.Backup holds
+// a complete backup, which it does before anything is replaced. A replacement
+// failing before then leaves the backup incomplete, with nothing to revert.
+func (r *Replacer) BackedUp() bool {
+	return r.backedUp
+}
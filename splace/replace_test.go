@@ -0,0 +1,82 @@
+package splace
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+var valueReplacerTests = []struct {
+	opt ReplaceOptions
+	in  string
+	out string
+}{
+	{ReplaceOptions{Mode: Contains, Search: "old.com", Replace: "new.com"}, "http://old.com/old.com", "http://new.com/new.com"},
+	{ReplaceOptions{Mode: Equals, Search: "old.com", Replace: "new.com"}, "http://old.com", "http://old.com"},
+	{ReplaceOptions{Mode: Regexp, Search: `(\w+)\.old\.com`, Replace: "$1a.new.com"}, "www.old.com", "wwwa.new.com"},
+	{ReplaceOptions{Mode: Regexp, Search: `price: (\d+)`, Replace: `$$1 \$x $x`}, "price: 5", "$5 $x $x"},
+	{
+		ReplaceOptions{Mode: Contains, Search: "old.com", Replace: "www.new.com", Serialized: true},
+		`a:1:{i:0;s:14:"http://old.com";}`,
+		`a:1:{i:0;s:18:"http://www.new.com";}`,
+	},
+}
+
+func TestValueReplacer(t *testing.T) {
+	for i, test := range valueReplacerTests {
+		replace, err := test.opt.valueReplacer()
+		if err != nil {
+			t.Fatalf("failed test %d: %v", i, err)
+		}
+		if out := replace(test.in); out != test.out {
+			t.Errorf("failed test %d: expected %q, got %q", i, test.out, out)
+		}
+	}
+}
+
+func TestReplacableColumns(t *testing.T) {
+	cols, skipped := replacableColumns(mysqlDialect{}, []ColumnInfo{
+		{Column: "title", Type: "varchar(255)", TableType: BaseTable},
+		{Column: "slug", Type: "varchar(255)", TableType: BaseTable, Generated: true},
+		{Column: "created", Type: "datetime", TableType: BaseTable},
+	})
+	if !reflect.DeepEqual(cols, []string{"title"}) {
+		t.Errorf("expected only title to be replaced in, got %q", cols)
+	}
+	if len(skipped) != 2 || skipped[0].Column != "slug" || skipped[1].Column != "created" {
+		t.Errorf("expected slug and created to be skipped, got %v", skipped)
+	}
+
+	cols, skipped = replacableColumns(mysqlDialect{}, []ColumnInfo{
+		{Column: "title", Type: "varchar(255)", TableType: View},
+	})
+	if len(cols) != 0 || len(skipped) != 1 {
+		t.Errorf("expected the columns of views to be skipped, got %q %v", cols, skipped)
+	}
+}
+
+func TestIsUndefinedFunction(t *testing.T) {
+	tests := []struct {
+		err       error
+		undefined bool
+	}{
+		{&mysql.MySQLError{Number: 1305, Message: "FUNCTION wp.REGEXP_REPLACE does not exist"}, true},
+		{&mysql.MySQLError{Number: 1045, Message: "Access denied"}, false},
+		{&pq.Error{Code: "42883"}, true},
+		{errors.New("FUNCTION wp.REGEXP_REPLACE does not exist"), true},
+		{errors.New("SQLSTATE[42000]: Syntax error or access violation: 1305 FUNCTION wp.REGEXP_REPLACE does not exist"), true},
+		{errors.New("SQLSTATE[42000]: Syntax error or access violation: 1630 FUNCTION wp.REGEXP_REPLACE does not exist. Check the 'Function Name Parsing and Resolution' section in the Reference Manual"), true},
+		{errors.New(`pq: function regexp_replace(integer, unknown, unknown) does not exist`), true},
+		{errors.New("SQLSTATE[42S02]: Base table or view not found: 1146 Table 'wp.wp_posts' doesn't exist"), false},
+		{errors.New("no such function: regexp_replace"), true},
+		{errors.New("invalid connection"), false},
+	}
+	for _, test := range tests {
+		if undefined := isUndefinedFunction(test.err); undefined != test.undefined {
+			t.Errorf("%v: expected %v, got %v", test.err, test.undefined, undefined)
+		}
+	}
+}
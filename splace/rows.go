@@ -0,0 +1,189 @@
+package splace
+
+import (
+	"context"
+	"time"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+// defaultRowLimit is the number of rows fetched at a time when
+// replacing in Go and ReplaceOptions.Limit is zero.
+const defaultRowLimit = 1000
+
+// keyedRow is a row selected by queryBuilder.selectKeyed.
+type keyedRow struct {
+	key    []string
+	values []string
+}
+
+// cellChange is a change to a single column of a row.
+type cellChange struct {
+	column string
+	old    string
+	new    string
+
+	// orNull also matches a NULL cell, which reads as an empty old.
+	orNull bool
+}
+
+// replaceTableRows fetches the rows matching the search and makes the replacement
+// in Go, then writes back the changed rows one by one by key.
+// Tables without a key are fetched at once and updated by value,
+// unless the replacement is journaled, in which case they're skipped.
+func (r *Replacer) replaceTableRows(qb *queryBuilder, table string, columns []string, skipped []SkippedColumn, replace func(string) string) error {
+	key, err := tableKey(r.ctx, r.db, qb.dialect(), table)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 && r.opt.Journal != nil {
+		// Rolling back by value would also revert rows that held
+		// the replaced value before the replacement.
+		for _, col := range columns {
+			skipped = append(skipped, SkippedColumn{
+				Column: col,
+				Reason: "tables without a key can't be journaled",
+			})
+		}
+		r.skipTable(table, skipped)
+		return nil
+	}
+	opt := queryOptions{
+		table:    table,
+		columns:  columns,
+		limit:    r.opt.Limit,
+		pairs:    r.opt.pairs(),
+		everyRow: r.opt.Mapping != nil,
+	}
+	if opt.limit == 0 {
+		opt.limit = defaultRowLimit
+	}
+	if len(key) == 0 {
+		// Without a key there's no way to tell where a page ends.
+		opt.limit = 0
+	}
+	query, args := qb.selectKeyed(opt, key, nil)
+
+	iterations := make(chan int)
+	defer close(iterations)
+
+	r.results <- ReplaceResult{
+		Table:        table,
+		Schema:       tableSchema(table),
+		SQL:          query,
+		Skipped:      skipped,
+		AffectedRows: iterations,
+		Start:        time.Now(),
+	}
+
+	updated := map[cellChange]bool{}
+	for {
+		rows, err := fetchRows(r.ctx, r.db, query, args, len(key))
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		for _, row := range rows {
+			var changes []cellChange
+			for i, col := range columns {
+				if v := replace(row.values[i]); v != row.values[i] {
+					changes = append(changes, cellChange{
+						column: col,
+						old:    row.values[i],
+						new:    v,
+					})
+				}
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			if len(key) > 0 {
+				affected, err := r.updateRow(qb, table, key, row.key, changes)
+				if err != nil {
+					return err
+				}
+				n += affected
+				continue
+			}
+			// Without a key, rows are updated by value one column at a time,
+			// since other rows may share some of the values but not all of them.
+			for _, c := range changes {
+				if updated[c] {
+					continue
+				}
+				updated[c] = true
+				affected, err := r.updateRow(qb, table, nil, nil, []cellChange{c})
+				if err != nil {
+					return err
+				}
+				n += affected
+			}
+		}
+		if n > 0 {
+			iterations <- n
+		}
+
+		if opt.limit == 0 || len(rows) < opt.limit {
+			return nil
+		}
+		query, args = qb.selectKeyed(opt, key, rows[len(rows)-1].key)
+	}
+}
+
+func (r *Replacer) updateRow(qb *queryBuilder, table string, key, keyValues []string, changes []cellChange) (int, error) {
+	query, args := qb.updateRow(table, key, keyValues, changes)
+	result, err := r.db.Exec(r.ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		return int(rowsAffected), err
+	}
+	for _, c := range changes {
+		r.opt.countHits(c.old)
+	}
+	if r.opt.Journal == nil {
+		return int(rowsAffected), nil
+	}
+	for _, c := range changes {
+		err := r.opt.Journal.Write(JournalEntry{
+			Table:     table,
+			Key:       key,
+			KeyValues: keyValues,
+			Column:    c.column,
+			Old:       c.old,
+			New:       c.new,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(rowsAffected), nil
+}
+
+// fetchRows runs a query selecting keyLen key columns followed by values, such as those
+// built by queryBuilder.selectKeyed, and returns all of its rows,
+// so the connection is free for updates.
+func fetchRows(ctx context.Context, db querier.Querier, query string, args []interface{}, keyLen int) ([]keyedRow, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []keyedRow
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return nil, err
+		}
+		cpy := make([]string, len(row))
+		copy(cpy, row)
+		result = append(result, keyedRow{
+			key:    cpy[:keyLen],
+			values: cpy[keyLen:],
+		})
+	}
+	return result, rows.Err()
+}
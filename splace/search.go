@@ -2,6 +2,10 @@ package splace
 
 import (
 	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,10 +24,39 @@ type SearchOptions struct {
 	// while with a higher limit the operation would complete faster.
 	// Set to 0 for no limit.
 	Limit int
+
+	// Count counts the matching rows of each table and column with an aggregate
+	// query per table, rather than fetching them. Results carry the counts in
+	// Count, and their Rows are closed without any rows. Limit is ignored.
+	Count bool
+
+	// Occurrences, with Count, also counts the occurrences of the search in
+	// each column. Only Equals and Contains modes support it, and Contains
+	// only with a non-empty search.
+	Occurrences bool
+
+	// Snippets, if positive, sends the matches of each row in Matches rather than
+	// the whole row in Rows: the matching cells, with snippets of up to Snippets
+	// characters around each match, and the key of the row, by which
+	// Splace.Value fetches whole values on demand. Only the key and the searched
+	// columns are selected.
+	Snippets int
+
+	// Objects also searches the definitions of the views, stored routines,
+	// triggers and events of the schemas of Tables, sending a result for each
+	// matching object after the tables. Only MySQL supports it.
+	Objects bool
 }
 
 type SearchResult struct {
-	Table   string
+	Table string
+	// Schema is the schema of a table qualified as schema.table, or empty.
+	Schema string
+	// Object is the kind of the object, such as ObjectView, for results of
+	// SearchOptions.Objects, whose only row is the definition of the object.
+	// It's empty for tables.
+	Object string
+
 	Columns []string
 	SQL     string
 
@@ -32,9 +65,39 @@ type SearchResult struct {
 	// Rows is closed when we're done searching this table.
 	Rows <-chan []string
 
+	// Key lists the columns of the key of the table, whose values identify the
+	// rows, such as for Splace.UpdateCell and Splace.ReplaceInRows. It's empty
+	// for tables without a key. RowKey returns the key values of a row of Rows.
+	Key []string
+
+	// Matches transmits the matches of each matching row with SearchOptions.Snippets,
+	// while Rows is closed without any rows. Otherwise it's nil.
+	// Matches is closed when we're done searching this table.
+	Matches <-chan MatchRow
+
+	// Count holds the counts of SearchOptions.Count, or nil.
+	Count *SearchCount
+
 	Start time.Time
 }
 
+// SearchCount counts the matches of a table.
+type SearchCount struct {
+	// Rows is the number of rows with a match in any column.
+	Rows    int64
+	Columns []ColumnCount
+}
+
+// ColumnCount counts the matches of a column.
+type ColumnCount struct {
+	Column string
+	Rows   int64
+
+	// Occurrences is the number of occurrences of the search in the column,
+	// counted with SearchOptions.Occurrences.
+	Occurrences int64
+}
+
 type Searcher struct {
 	ctx       context.Context
 	ctxCancel context.CancelFunc
@@ -75,6 +138,27 @@ func (s *Searcher) start() {
 		s.done <- wgErr
 	}()
 
+	if !dialectOf(s.db.Config().Engine).supports(s.opt.Mode, false) {
+		wgErr = ErrUnsupportedMode
+		return
+	}
+	if s.opt.Objects && s.db.Config().Engine != querier.MySQL {
+		wgErr = ErrUnsupportedObjects
+		return
+	}
+	if err := s.opt.checkOccurrences(); err != nil {
+		wgErr = err
+		return
+	}
+	var find func(string) [][]int
+	if s.opt.Snippets > 0 && !s.opt.Count {
+		var err error
+		if find, err = s.opt.matchFinder(s.db.Config().Engine); err != nil {
+			wgErr = err
+			return
+		}
+	}
+
 	// Produce a search task for each table.
 	go func() {
 		defer close(tasks)
@@ -99,7 +183,12 @@ func (s *Searcher) start() {
 		go func() {
 			defer wg.Done()
 			for task := range tasks {
-				err := s.searchTable(task.table, task.columns)
+				var err error
+				if s.opt.Count {
+					err = s.countTable(task.table, task.columns)
+				} else {
+					err = s.searchTable(task.table, task.columns, find)
+				}
 				if err != nil && s.ctx.Err() != context.Canceled {
 					// Cancel all tasks.
 					s.ctxCancel()
@@ -110,65 +199,314 @@ func (s *Searcher) start() {
 	}
 
 	wg.Wait()
+	if wgErr == nil && s.opt.Objects {
+		wgErr = s.searchObjects()
+	}
 }
 
-func (s *Searcher) searchTable(table string, columns []string) error {
-	qb := &queryBuilder{}
+// searchTable sends the rows of a table matching the search, or their matches
+// found by find if it isn't nil.
+func (s *Searcher) searchTable(table string, columns []string, find func(string) [][]int) error {
+	qb := newQueryBuilder(s.db.Config().Engine)
 	iterations := make(chan []string, 128)
 	defer close(iterations)
+	var matches chan MatchRow
+	if find != nil {
+		matches = make(chan MatchRow, 128)
+		defer close(matches)
+	}
 
-	offset := 0
-	for {
-		query := qb.build(queryOptions{
-			table:   table,
-			columns: columns,
-			mode:    s.opt.Mode,
-			search:  s.opt.Search,
-			offset:  offset,
-			limit:   s.opt.Limit,
-		})
-
-		rows, err := s.db.Query(s.ctx, query)
+	opt := queryOptions{
+		table:   table,
+		columns: columns,
+		mode:    s.opt.Mode,
+		search:  s.opt.Search,
+		limit:   s.opt.Limit,
+	}
+	query, args := qb.build(opt), []interface{}(nil)
+	if find != nil {
+		query = qb.selectColumns(opt)
+	}
+
+	// Pages follow the table's key when it has one, since skipping rows
+	// with OFFSET gets slower the further it goes.
+	key, err := tableKey(s.ctx, s.db, qb.dialect(), table)
+	if err != nil {
+		return err
+	}
+	if s.opt.Limit > 0 || find != nil {
+		if len(key) > 0 {
+			query, args = qb.selectPage(opt, key, nil)
+			if find != nil {
+				query, args = qb.selectKeyed(opt, key, nil)
+			}
+		}
+	}
+
+	// keyIndex holds the positions of the key columns in the result columns.
+	var keyIndex []int
+	for first := true; ; first = false {
+		rows, err := s.db.Query(s.ctx, query, args...)
 		if err != nil {
 			return err
 		}
-		defer rows.Close()
 
-		if offset == 0 {
+		if first {
 			resultColumns, err := rows.Columns()
 			if err != nil {
+				rows.Close()
 				return err
 			}
+			keyIndex = columnIndexes(resultColumns, key)
 
-			s.results <- SearchResult{
+			result := SearchResult{
 				Table:   table,
+				Schema:  tableSchema(table),
 				Columns: resultColumns,
 				SQL:     query,
 				Rows:    iterations,
+				Key:     key,
 				Start:   time.Now(),
 			}
+			if find != nil {
+				result.Columns, result.Matches = columns, matches
+			}
+			s.results <- result
 		}
 
 		n := 0
+		var last []string
 		for rows.Next() {
 			row, err := rows.ScanStrings()
 			if err != nil {
+				rows.Close()
 				return err
 			}
 			cpy := make([]string, len(row))
 			copy(cpy, row)
-			iterations <- cpy
+			if find != nil {
+				matches <- s.matchRow(cpy, len(key), columns, find)
+			} else {
+				iterations <- cpy
+			}
+			last = cpy
 			n++
 		}
-		if rows.Err() != nil {
+		rows.Close()
+		if err := rows.Err(); err != nil {
 			return err
 		}
-		if s.opt.Limit == 0 || n == 0 {
+		if s.opt.Limit == 0 || n < s.opt.Limit {
 			return nil
 		}
 
-		offset += s.opt.Limit
+		if keyIndex != nil {
+			after := make([]string, len(keyIndex))
+			for i, idx := range keyIndex {
+				after[i] = last[idx]
+			}
+			if find != nil {
+				query, args = qb.selectKeyed(opt, key, after)
+			} else {
+				query, args = qb.selectPage(opt, key, after)
+			}
+		} else {
+			opt.offset += s.opt.Limit
+			if find != nil {
+				query = qb.selectColumns(opt)
+			} else {
+				query = qb.build(opt)
+			}
+		}
+	}
+}
+
+// RowKey returns the values of the key columns of a row of Rows,
+// or nil for tables without a key.
+func (r SearchResult) RowKey(row []string) []string {
+	indexes := columnIndexes(r.Columns, r.Key)
+	if indexes == nil {
+		return nil
+	}
+	values := make([]string, len(indexes))
+	for i, idx := range indexes {
+		values[i] = row[idx]
+	}
+	return values
+}
+
+// matchRow returns the matches of a row selected by selectKeyed or selectColumns,
+// whose first keyLen values are those of the key, followed by those of columns.
+// If find misses what the database matched, such as by a collation ignoring
+// accents, the non-empty values of the row are sent whole.
+func (s *Searcher) matchRow(row []string, keyLen int, columns []string, find func(string) [][]int) MatchRow {
+	m := MatchRow{Key: row[:keyLen]}
+	for i, col := range columns {
+		if cell, ok := cellMatch(col, row[keyLen+i], find, s.opt.Snippets); ok {
+			m.Cells = append(m.Cells, cell)
+		}
+	}
+	if len(m.Cells) > 0 {
+		return m
+	}
+	for i, col := range columns {
+		if cell, ok := cellMatch(col, row[keyLen+i], wholeValue, s.opt.Snippets); ok {
+			m.Cells = append(m.Cells, cell)
+		}
+	}
+	return m
+}
+
+// countTable counts the matches of a table with a single query.
+func (s *Searcher) countTable(table string, columns []string) error {
+	qb := newQueryBuilder(s.db.Config().Engine)
+	query := qb.count(queryOptions{
+		table:   table,
+		columns: columns,
+		mode:    s.opt.Mode,
+		search:  s.opt.Search,
+	}, s.opt.Occurrences)
+	start := time.Now()
+
+	rows, err := s.db.Query(s.ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var row []string
+	for rows.Next() {
+		if row, err = rows.ScanStrings(); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	step := 1
+	if s.opt.Occurrences {
+		step = 2
+	}
+	if len(row) != 1+len(columns)*step {
+		return fmt.Errorf("counting the matches of %s returned %d columns", table, len(row))
+	}
+
+	// Sums are NULL rather than 0 when no row matches.
+	count := &SearchCount{}
+	count.Rows, _ = strconv.ParseInt(row[0], 10, 64)
+	for i, col := range columns {
+		c := ColumnCount{Column: col}
+		c.Rows, _ = strconv.ParseInt(row[1+i*step], 10, 64)
+		if s.opt.Occurrences {
+			c.Occurrences, _ = strconv.ParseInt(row[2+i*step], 10, 64)
+		}
+		count.Columns = append(count.Columns, c)
+	}
+
+	iterations := make(chan []string)
+	close(iterations)
+	s.results <- SearchResult{
+		Table:   table,
+		Schema:  tableSchema(table),
+		Columns: columns,
+		SQL:     query,
+		Rows:    iterations,
+		Count:   count,
+		Start:   start,
+	}
+	return nil
+}
+
+// columnIndexes returns the position of each of names in columns,
+// or nil if any of them is missing.
+func columnIndexes(columns, names []string) []int {
+	if len(names) == 0 {
+		return nil
+	}
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		indexes[i] = -1
+		for j, col := range columns {
+			if col == name {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] == -1 {
+			return nil
+		}
+	}
+	return indexes
+}
+
+// checkOccurrences returns an error if occurrences can't be counted with the options.
+func (opt SearchOptions) checkOccurrences() error {
+	if !opt.Count || !opt.Occurrences || opt.Mode == Equals {
+		return nil
+	}
+	if opt.Mode != Contains {
+		return ErrUnsupportedOccurrences
+	}
+	if opt.Search == "" {
+		// The count of occurrences is divided by the length of the search.
+		return ErrEmptyOccurrences
+	}
+	return nil
+}
+
+// valueMatcher returns a function reporting whether a single value matches the search,
+// for searching outside of a database.
+func (opt SearchOptions) valueMatcher() (func(string) bool, error) {
+	switch opt.Mode {
+	case Equals:
+		return func(s string) bool {
+			return s == opt.Search
+		}, nil
+	case Contains:
+		return func(s string) bool {
+			return strings.Contains(s, opt.Search)
+		}, nil
+	case Like:
+		re, err := regexp.Compile(likeRegexp(opt.Search))
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	case Regexp:
+		re, err := regexp.Compile(opt.Search)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	return nil, ErrUnsupportedMode
+}
+
+// likeRegexp converts a LIKE pattern to a regular expression matching the same values.
+func likeRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(`.*`)
+		case c == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if escaped {
+		// A trailing backslash stands for itself.
+		b.WriteString(`\\`)
 	}
+	b.WriteString(`$`)
+	return b.String()
 }
 
 func (s *Searcher) Results() <-chan SearchResult {
@@ -0,0 +1,206 @@
+package splace
+
+import (
+	"strconv"
+	"strings"
+)
+
+// replaceSerialized applies replace to every string inside a PHP serialized value,
+// such as those stored by WordPress in wp_options and wp_postmeta, and corrects the
+// length prefixes of the strings it changes. Strings that hold serialized values
+// themselves are rewritten recursively.
+//
+// ok is false if value isn't a valid serialized value.
+func replaceSerialized(value string, replace func(string) string) (result string, ok bool) {
+	// Cheap check to skip the majority of values, which aren't serialized.
+	if len(value) < 2 || value[1] != ':' && value[1] != ';' {
+		return "", false
+	}
+	p := serializedParser{s: value, replace: replace}
+	if !p.value() || p.pos != len(p.s) {
+		return "", false
+	}
+	return p.out.String(), true
+}
+
+// serializedParser parses PHP's serialize() format while writing it out
+// with its strings replaced.
+type serializedParser struct {
+	s       string
+	pos     int
+	out     strings.Builder
+	replace func(string) string
+}
+
+func (p *serializedParser) value() bool {
+	if p.pos >= len(p.s) {
+		return false
+	}
+	start := p.pos
+	switch p.s[p.pos] {
+	case 'N':
+		if !p.expect("N;") {
+			return false
+		}
+	case 'b', 'i', 'r', 'R':
+		p.pos++
+		if !p.expect(":") || !p.integer() || !p.expect(";") {
+			return false
+		}
+	case 'd':
+		// Floats may also be INF, -INF or NAN.
+		p.pos++
+		if !p.expect(":") {
+			return false
+		}
+		end := strings.IndexByte(p.s[p.pos:], ';')
+		if end <= 0 {
+			return false
+		}
+		if _, err := strconv.ParseFloat(p.s[p.pos:p.pos+end], 64); err != nil {
+			switch p.s[p.pos : p.pos+end] {
+			case "INF", "-INF", "NAN":
+			default:
+				return false
+			}
+		}
+		p.pos += end + 1
+	case 's':
+		p.pos++
+		n, ok := p.length()
+		if !ok || !p.expect(`"`) || p.pos+n > len(p.s) {
+			return false
+		}
+		str := p.s[p.pos : p.pos+n]
+		p.pos += n
+		if !p.expect(`";`) {
+			return false
+		}
+		if nested, ok := replaceSerialized(str, p.replace); ok {
+			str = nested
+		} else {
+			str = p.replace(str)
+		}
+		p.out.WriteString("s:" + strconv.Itoa(len(str)) + `:"` + str + `";`)
+		return true
+	case 'E':
+		// Enums are written as E:length:"Class:Case", which isn't worth replacing in.
+		p.pos++
+		n, ok := p.length()
+		if !ok || !p.expect(`"`) || p.pos+n > len(p.s) {
+			return false
+		}
+		p.pos += n
+		if !p.expect(`";`) {
+			return false
+		}
+	case 'a':
+		p.pos++
+		n, ok := p.length()
+		if !ok {
+			return false
+		}
+		p.out.WriteString(p.s[start:p.pos])
+		return p.members(n)
+	case 'O':
+		p.pos++
+		if !p.class() {
+			return false
+		}
+		n, ok := p.length()
+		if !ok {
+			return false
+		}
+		p.out.WriteString(p.s[start:p.pos])
+		return p.members(n)
+	case 'C':
+		// Classes implementing Serializable write arbitrary data, which is
+		// only replaced in when it happens to be serialized as well.
+		p.pos++
+		if !p.class() {
+			return false
+		}
+		header := p.s[start:p.pos]
+		n, ok := p.length()
+		if !ok || !p.expect("{") || p.pos+n > len(p.s) {
+			return false
+		}
+		data := p.s[p.pos : p.pos+n]
+		p.pos += n
+		if !p.expect("}") {
+			return false
+		}
+		if nested, ok := replaceSerialized(data, p.replace); ok {
+			data = nested
+		}
+		p.out.WriteString(header + ":" + strconv.Itoa(len(data)) + ":{" + data + "}")
+		return true
+	default:
+		return false
+	}
+	p.out.WriteString(p.s[start:p.pos])
+	return true
+}
+
+// members parses the n key-value pairs of an array or object, including the braces.
+func (p *serializedParser) members(n int) bool {
+	if !p.expect("{") {
+		return false
+	}
+	p.out.WriteByte('{')
+	for i := 0; i < 2*n; i++ {
+		if !p.value() {
+			return false
+		}
+	}
+	if !p.expect("}") {
+		return false
+	}
+	p.out.WriteByte('}')
+	return true
+}
+
+// class parses the :length:"name" part of an object.
+func (p *serializedParser) class() bool {
+	n, ok := p.length()
+	if !ok || !p.expect(`"`) || p.pos+n > len(p.s) {
+		return false
+	}
+	p.pos += n
+	return p.expect(`"`)
+}
+
+// length parses :n: and returns n.
+func (p *serializedParser) length() (int, bool) {
+	if !p.expect(":") {
+		return 0, false
+	}
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil || !p.expect(":") {
+		return 0, false
+	}
+	return n, true
+}
+
+func (p *serializedParser) integer() bool {
+	if p.pos < len(p.s) && (p.s[p.pos] == '-' || p.s[p.pos] == '+') {
+		p.pos++
+	}
+	digits := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	return p.pos > digits
+}
+
+func (p *serializedParser) expect(token string) bool {
+	if !strings.HasPrefix(p.s[p.pos:], token) {
+		return false
+	}
+	p.pos += len(token)
+	return true
+}
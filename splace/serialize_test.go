@@ -0,0 +1,68 @@
+package splace
+
+import (
+	"strings"
+	"testing"
+)
+
+var replaceSerializedTests = []struct {
+	in  string
+	out string
+	ok  bool
+}{
+	{
+		`s:22:"http://old.example.com";`,
+		`s:27:"https://www.new.example.com";`,
+		true,
+	},
+	{
+		`a:2:{s:4:"home";s:18:"http://old.example";s:5:"count";i:3;}`,
+		`a:2:{s:4:"home";s:23:"https://www.new.example";s:5:"count";i:3;}`,
+		true,
+	},
+	{
+		`O:8:"stdClass":2:{s:3:"url";s:24:"http://old.example/ünï";s:7:"enabled";b:1;}`,
+		`O:8:"stdClass":2:{s:3:"url";s:29:"https://www.new.example/ünï";s:7:"enabled";b:1;}`,
+		true,
+	},
+	{
+		// Serialized inside serialized.
+		`a:1:{i:0;s:26:"s:18:"http://old.example";";}`,
+		`a:1:{i:0;s:31:"s:23:"https://www.new.example";";}`,
+		true,
+	},
+	{
+		`a:3:{i:0;N;i:1;d:0.5;i:2;d:-INF;}`,
+		`a:3:{i:0;N;i:1;d:0.5;i:2;d:-INF;}`,
+		true,
+	},
+	{
+		// The length doesn't match.
+		`s:5:"http://old.example";`,
+		``,
+		false,
+	},
+	{
+		`http://old.example`,
+		``,
+		false,
+	},
+	{
+		// Trailing garbage.
+		`i:1;i:2;`,
+		``,
+		false,
+	},
+}
+
+func TestReplaceSerialized(t *testing.T) {
+	replace := func(s string) string {
+		return strings.Replace(s, "http://old.example", "https://www.new.example", -1)
+	}
+	for i, test := range replaceSerializedTests {
+		out, ok := replaceSerialized(test.in, replace)
+		if ok != test.ok || out != test.out {
+			t.Errorf("failed test %d: expected %q (%v), got %q (%v)", i, test.out, test.ok, out, ok)
+		}
+	}
+}
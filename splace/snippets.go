@@ -0,0 +1,218 @@
+package splace
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+// snippetsPerValue is the most snippets sent for the matches of a single value.
+const snippetsPerValue = 10
+
+// MatchRow is a row matching the search, sent in place of the row itself
+// with SearchOptions.Snippets.
+type MatchRow struct {
+	// Key holds the values of the key columns of SearchResult.Key, which
+	// Splace.Value takes to fetch whole values. It's empty for tables
+	// without a key.
+	Key []string
+
+	// Cells are the matching cells of the row.
+	Cells []CellMatch
+}
+
+// CellMatch is a cell matching the search.
+type CellMatch struct {
+	Column string
+
+	// Length is the length of the value, in characters.
+	Length int
+
+	// Matches is the number of matches in the value, of which
+	// the first snippetsPerValue have snippets.
+	Matches  int
+	Snippets []Snippet
+}
+
+// Snippet is a match with the context around it.
+type Snippet struct {
+	// Text is the match with up to SearchOptions.Snippets characters on each side.
+	Text string
+
+	// Offset is the position of Text in the value, and Start and End
+	// are the positions of the match in the value, in characters.
+	Offset int
+	Start  int
+	End    int
+}
+
+// matchFinder returns a function finding the byte offsets of the matches of the
+// search in a value, for snippets of values the database of engine found matching.
+//
+// The matches follow the database where Go can: Equals ignores case and trailing
+// spaces in MySQL and SQL Server, as their default collations do, and so does
+// Regexp in MySQL. Accents, which some collations ignore too, aren't.
+func (opt SearchOptions) matchFinder(engine querier.Engine) (func(string) [][]int, error) {
+	ignoreCase := engine == querier.MySQL || engine == querier.SQLServer
+	switch opt.Mode {
+	case Equals:
+		return func(s string) [][]int {
+			if ignoreCase {
+				if !strings.EqualFold(strings.TrimRight(s, " "), strings.TrimRight(opt.Search, " ")) {
+					return nil
+				}
+			} else if s != opt.Search {
+				return nil
+			}
+			return [][]int{{0, len(s)}}
+		}, nil
+	case Contains:
+		return func(s string) [][]int {
+			if opt.Search == "" {
+				return nil
+			}
+			var matches [][]int
+			for i := 0; ; {
+				j := strings.Index(s[i:], opt.Search)
+				if j == -1 {
+					return matches
+				}
+				matches = append(matches, []int{i + j, i + j + len(opt.Search)})
+				i += j + len(opt.Search)
+			}
+		}, nil
+	case Like:
+		// Wildcards at the ends of the pattern stand for whatever surrounds
+		// the matches, which are found by the rest of the pattern.
+		pattern := strings.TrimSuffix(strings.TrimPrefix(likeRegexp(opt.Search), `(?s)^`), `$`)
+		start, end := `^`, `$`
+		for strings.HasPrefix(pattern, `.*`) {
+			pattern, start = pattern[2:], ``
+		}
+		for strings.HasSuffix(pattern, `.*`) {
+			pattern, end = pattern[:len(pattern)-2], ``
+		}
+		if pattern == "" {
+			return wholeValue, nil
+		}
+		re, err := regexp.Compile(`(?s)` + start + pattern + end)
+		if err != nil {
+			return nil, err
+		}
+		return nonEmptyMatches(re), nil
+	case Regexp:
+		pattern := opt.Search
+		if engine == querier.MySQL {
+			pattern = `(?i)` + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return nonEmptyMatches(re), nil
+	}
+	return nil, ErrUnsupportedMode
+}
+
+func wholeValue(s string) [][]int {
+	if s == "" {
+		return nil
+	}
+	return [][]int{{0, len(s)}}
+}
+
+// nonEmptyMatches finds the matches of re, leaving out empty matches.
+func nonEmptyMatches(re *regexp.Regexp) func(string) [][]int {
+	return func(s string) [][]int {
+		var matches [][]int
+		for _, m := range re.FindAllStringIndex(s, -1) {
+			if m[1] > m[0] {
+				matches = append(matches, m)
+			}
+		}
+		return matches
+	}
+}
+
+// cellMatch returns the snippets of the matches in a value, with window
+// characters of context on each side, or false if nothing matches.
+func cellMatch(column, value string, find func(string) [][]int, window int) (CellMatch, bool) {
+	matches := find(value)
+	if len(matches) == 0 {
+		return CellMatch{}, false
+	}
+	cell := CellMatch{
+		Column:  column,
+		Length:  utf8.RuneCountInString(value),
+		Matches: len(matches),
+	}
+	// Byte offsets are converted to characters as the value is walked,
+	// since matches don't overlap.
+	pos, chars := 0, 0
+	charsAt := func(i int) int {
+		chars += utf8.RuneCountInString(value[pos:i])
+		pos = i
+		return chars
+	}
+	for i, m := range matches {
+		if i == snippetsPerValue {
+			break
+		}
+		from := runesBack(value, m[0], window)
+		to := runesForward(value, m[1], window)
+		start, end := charsAt(m[0]), charsAt(m[1])
+		cell.Snippets = append(cell.Snippets, Snippet{
+			Text:   value[from:to],
+			Offset: start - utf8.RuneCountInString(value[from:m[0]]),
+			Start:  start,
+			End:    end,
+		})
+	}
+	return cell, true
+}
+
+// runesBack returns the byte offset n characters before i.
+func runesBack(s string, i, n int) int {
+	for ; n > 0 && i > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+	}
+	return i
+}
+
+// runesForward returns the byte offset n characters after i.
+func runesForward(s string, i, n int) int {
+	for ; n > 0 && i < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return i
+}
+
+// Value fetches the whole value of a column in the row with the given key values,
+// such as for a match sent with SearchOptions.Snippets.
+func (s *Splace) Value(ctx context.Context, table, column string, key, values []string) (string, error) {
+	if len(key) == 0 || len(key) != len(values) {
+		return "", ErrRowNotFound
+	}
+	query, args := newQueryBuilder(s.db.Config().Engine).selectRow(table, []string{column}, key, values)
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", ErrRowNotFound
+	}
+	row, err := rows.ScanStrings()
+	if err != nil {
+		return "", err
+	}
+	return row[0], nil
+}
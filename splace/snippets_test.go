@@ -0,0 +1,100 @@
+package splace
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zippoxer/splace/splace/querier"
+)
+
+func TestCellMatch(t *testing.T) {
+	tests := []struct {
+		opt      SearchOptions
+		engine   querier.Engine
+		value    string
+		expected CellMatch
+	}{
+		{
+			SearchOptions{Search: "old", Mode: Contains, Snippets: 2},
+			querier.SQLite,
+			"é old, ab old",
+			CellMatch{Column: "c", Length: 13, Matches: 2, Snippets: []Snippet{
+				{Text: "é old, ", Offset: 0, Start: 2, End: 5},
+				{Text: "b old", Offset: 8, Start: 10, End: 13},
+			}},
+		},
+		{
+			SearchOptions{Search: "%o_d%", Mode: Like, Snippets: 1},
+			querier.SQLite,
+			"an old odd",
+			CellMatch{Column: "c", Length: 10, Matches: 2, Snippets: []Snippet{
+				{Text: " old ", Offset: 2, Start: 3, End: 6},
+				{Text: " odd", Offset: 6, Start: 7, End: 10},
+			}},
+		},
+		{
+			SearchOptions{Search: "old%", Mode: Like, Snippets: 1},
+			querier.SQLite,
+			"old and old",
+			CellMatch{Column: "c", Length: 11, Matches: 1, Snippets: []Snippet{
+				{Text: "old ", Offset: 0, Start: 0, End: 3},
+			}},
+		},
+		{
+			SearchOptions{Search: `o+`, Mode: Regexp, Snippets: 0},
+			querier.SQLite,
+			"foo",
+			CellMatch{Column: "c", Length: 3, Matches: 1, Snippets: []Snippet{
+				{Text: "oo", Offset: 1, Start: 1, End: 3},
+			}},
+		},
+		{
+			SearchOptions{Search: "Old.com ", Mode: Equals, Snippets: 2},
+			querier.MySQL,
+			"OLD.COM",
+			CellMatch{Column: "c", Length: 7, Matches: 1, Snippets: []Snippet{
+				{Text: "OLD.COM", Offset: 0, Start: 0, End: 7},
+			}},
+		},
+		{
+			SearchOptions{Search: `old\.c`, Mode: Regexp, Snippets: 1},
+			querier.MySQL,
+			"an Old.com",
+			CellMatch{Column: "c", Length: 10, Matches: 1, Snippets: []Snippet{
+				{Text: " Old.co", Offset: 2, Start: 3, End: 8},
+			}},
+		},
+	}
+	for _, test := range tests {
+		find, err := test.opt.matchFinder(test.engine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cell, ok := cellMatch("c", test.value, find, test.opt.Snippets)
+		if !ok {
+			t.Errorf("%q in %q: expected a match", test.opt.Search, test.value)
+			continue
+		}
+		if !reflect.DeepEqual(cell, test.expected) {
+			t.Errorf("%q in %q: expected %+v, got %+v", test.opt.Search, test.value, test.expected, cell)
+		}
+	}
+}
+
+func TestMatchRowFallback(t *testing.T) {
+	s := &Searcher{opt: SearchOptions{Search: "cafe", Mode: Equals, Snippets: 10}}
+	find, err := s.opt.matchFinder(querier.MySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An accent-insensitive collation matched the row, which Go doesn't follow.
+	m := s.matchRow([]string{"1", "Café", ""}, 1, []string{"name", "note"}, find)
+	expected := MatchRow{Key: []string{"1"}, Cells: []CellMatch{
+		{Column: "name", Length: 4, Matches: 1, Snippets: []Snippet{
+			{Text: "Café", Offset: 0, Start: 0, End: 4},
+		}},
+	}}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected %+v, got %+v", expected, m)
+	}
+}
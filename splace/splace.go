@@ -2,11 +2,25 @@ package splace
 
 import (
 	"context"
+	"errors"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com/zippoxer/splace/splace/querier"
 )
 
+var (
+	ErrUnsupportedMode = errors.New("mode is not supported by the database engine")
+
+	ErrUnsupportedOccurrences = errors.New("occurrences are only counted in equals and contains modes")
+	ErrEmptyOccurrences       = errors.New("occurrences of an empty search can't be counted")
+
+	ErrRowNotFound = errors.New("row not found")
+	ErrConflict    = errors.New("the row has changed since it was seen")
+	ErrNoKey       = errors.New("the table has no key to identify its rows")
+)
+
 type Mode int
 
 const (
@@ -18,17 +32,75 @@ const (
 
 type TableMap map[string][]ColumnInfo
 
+// Table types of ColumnInfo.
+const (
+	BaseTable = "BASE TABLE"
+	View      = "VIEW"
+)
+
+// Key memberships of ColumnInfo.
+const (
+	PrimaryKey = "PRI"
+	UniqueKey  = "UNI"
+)
+
 type ColumnInfo struct {
 	Column string
 	Type   string
+
+	// TableType is the type of the table of the column, such as BaseTable or View.
+	TableType string
+
+	// Generated is set for generated (computed) columns, which can't be updated.
+	// Expression is the expression generating them, where the engine tells it.
+	Generated  bool
+	Expression string
+
+	Nullable bool
+
+	// MaxLength is the maximum length of values of textual columns,
+	// in characters, or 0 for other columns and unlimited lengths.
+	MaxLength int64
+
+	Charset   string
+	Collation string
+
+	// Key is PrimaryKey for columns of the primary key, UniqueKey
+	// for columns of a unique key, or empty.
+	Key string
+}
+
+// columnInfo reads a row of the tables queries following the table name.
+func columnInfo(row []string) ColumnInfo {
+	// SQL Server reports -1 for the lengths of (max) columns.
+	maxLength, _ := strconv.ParseInt(row[6], 10, 64)
+	if maxLength < 0 {
+		maxLength = 0
+	}
+	return ColumnInfo{
+		Column:     row[0],
+		Type:       row[1],
+		TableType:  row[2],
+		Generated:  row[3] == "1",
+		Expression: row[4],
+		Nullable:   row[5] == "YES",
+		MaxLength:  maxLength,
+		Charset:    row[7],
+		Collation:  row[8],
+		Key:        row[9],
+	}
 }
 
 type Splace struct {
 	db querier.Querier
+	d  dialect
 }
 
 func New(db querier.Querier) *Splace {
-	return &Splace{db: db}
+	return &Splace{
+		db: db,
+		d:  dialectOf(db.Config().Engine),
+	}
 }
 
 func (s *Splace) Search(ctx context.Context, opt SearchOptions) *Searcher {
@@ -43,27 +115,91 @@ func (s *Splace) Replace(ctx context.Context, opt ReplaceOptions) *Replacer {
 	return r
 }
 
+// Preview finds the rows Replace would change with the same options
+// and the values they would change to, without writing anything.
+// Objects is ignored, so only the rows of tables are previewed.
+func (s *Splace) Preview(ctx context.Context, opt ReplaceOptions) *Previewer {
+	p := newPreviewer(ctx, s.db, opt)
+	go p.start()
+	return p
+}
+
 func (s *Splace) Tables(ctx context.Context) (TableMap, error) {
-	query := `SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE FROM ` +
-		`INFORMATION_SCHEMA.COLUMNS where TABLE_SCHEMA = ?`
-	rows, err := s.db.Query(ctx,
-		query,
-		s.db.Config().Database)
+	rows, err := s.db.Query(ctx, s.d.tablesQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := TableMap{}
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return nil, err
+		}
+		tables[row[0]] = append(tables[row[0]], columnInfo(row[1:]))
+	}
+	return tables, rows.Err()
+}
+
+// Schemas lists the schemas of the server, leaving out system schemas. The schemas
+// of PostgreSQL and SQL Server are those of the database, and the schemas of
+// SQLite are its attached databases.
+func (s *Splace) Schemas(ctx context.Context) ([]string, error) {
+	rows, err := s.db.Query(ctx, s.d.schemasQuery())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	var schemas []string
+	for rows.Next() {
+		row, err := rows.ScanStrings()
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, row[0])
+	}
+	return schemas, rows.Err()
+}
+
+// SchemaTables lists the tables of the schemas matching any of the patterns,
+// which are schema names or path.Match patterns such as tenant_*. Tables are
+// qualified as schema.table, including those of the default schema, so they
+// can be searched and replaced in together.
+func (s *Splace) SchemaTables(ctx context.Context, patterns []string) (TableMap, error) {
+	all, err := s.Schemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var schemas []string
+	for _, schema := range all {
+		for _, pattern := range patterns {
+			ok, err := path.Match(pattern, schema)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				schemas = append(schemas, schema)
+				break
+			}
+		}
+	}
 	tables := TableMap{}
+	if len(schemas) == 0 {
+		return tables, nil
+	}
+
+	rows, err := s.db.Query(ctx, s.d.schemaTablesQuery(schemas))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	for rows.Next() {
 		row, err := rows.ScanStrings()
 		if err != nil {
 			return nil, err
 		}
-		cols, _ := tables[row[0]]
-		tables[row[0]] = append(cols, ColumnInfo{
-			Column: row[1],
-			Type:   row[2],
-		})
+		table := row[0] + "." + row[1]
+		tables[table] = append(tables[table], columnInfo(row[2:]))
 	}
 	return tables, rows.Err()
 }
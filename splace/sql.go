@@ -3,6 +3,8 @@ package splace
 import (
 	"fmt"
 	"strings"
+
+	"github.com/zippoxer/splace/splace/querier"
 )
 
 type queryOptions struct {
@@ -15,85 +17,381 @@ type queryOptions struct {
 
 	update  bool
 	replace string
+
+	// pairs, if set, are matched and replaced in order instead of search and replace.
+	pairs []Pair
+
+	// everyRow selects every row with a value in any of the columns,
+	// rather than the rows matching the search, for replacements
+	// matched in Go.
+	everyRow bool
+}
+
+// patterns returns the pairs of the options, or the search and replacement as a single pair.
+func (opt queryOptions) patterns() []Pair {
+	if len(opt.pairs) > 0 {
+		return opt.pairs
+	}
+	return []Pair{{Search: opt.search, Replace: opt.replace, Mode: opt.mode}}
 }
 
 type queryBuilder struct {
 	b strings.Builder
+	d dialect
+}
+
+func newQueryBuilder(engine querier.Engine) *queryBuilder {
+	return &queryBuilder{d: dialectOf(engine)}
+}
+
+func (b *queryBuilder) dialect() dialect {
+	if b.d == nil {
+		return mysqlDialect{}
+	}
+	return b.d
 }
 
 func (b *queryBuilder) build(opt queryOptions) string {
+	d := b.dialect()
 	if opt.update {
-		fmt.Fprintf(&b.b, "UPDATE `%s` ", opt.table)
-		b.set(opt.columns, opt.search, opt.replace, opt.mode)
-	} else {
-		fmt.Fprintf(&b.b, "SELECT * FROM `%s` ", opt.table)
+		set := b.set(opt.columns, opt.patterns())
+		where := b.where(opt)
+		return d.updateQuery(opt.table, set, where, opt.limit)
 	}
+	where := b.where(opt)
+	return d.selectQuery("*", opt.table, where, "", opt.offset, opt.limit)
+}
 
-	b.where(opt.columns, opt.search, opt.mode)
+// selectColumns is like build, but selects only opt.columns.
+func (b *queryBuilder) selectColumns(opt queryOptions) string {
+	d := b.dialect()
+	columns := make([]string, len(opt.columns))
+	for i, col := range opt.columns {
+		columns[i] = d.quote(col)
+	}
+	where := b.where(opt)
+	return d.selectQuery(strings.Join(columns, ", "), opt.table, where, "", opt.offset, opt.limit)
+}
 
-	if opt.limit > 0 {
-		if opt.offset > 0 {
-			fmt.Fprintf(&b.b, "LIMIT %d, %d", opt.offset, opt.limit)
-		} else {
-			fmt.Fprintf(&b.b, "LIMIT %d", opt.limit)
+// selectRow builds a query selecting columns of the row with the given key values.
+func (b *queryBuilder) selectRow(table string, columns, key, values []string) (string, []interface{}) {
+	d := b.dialect()
+	args := &queryArgs{d: d}
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = d.quote(col)
+	}
+	where := make([]string, len(key))
+	for i, col := range key {
+		where[i] = d.quote(col) + " = " + args.add(values[i])
+	}
+	return d.selectQuery(strings.Join(quoted, ", "), table, "WHERE "+strings.Join(where, " AND ")+" ", "", 0, 0), args.args
+}
+
+// count builds a query counting the rows matching opt.search in any of opt.columns,
+// followed by the number of rows matching in each column and, if occurrences is
+// set, the number of occurrences of the search in each column.
+func (b *queryBuilder) count(opt queryOptions, occurrences bool) string {
+	d := b.dialect()
+	aggregates := []string{"COUNT(*)"}
+	for _, col := range opt.columns {
+		match := "CASE WHEN " + d.match(d.quote(col), opt.search, opt.mode) + " THEN 1 ELSE 0 END"
+		aggregates = append(aggregates, "SUM("+match+")")
+		if occurrences {
+			if opt.mode == Equals {
+				aggregates = append(aggregates, "SUM("+match+")")
+			} else {
+				aggregates = append(aggregates, "SUM(CASE WHEN "+d.match(d.quote(col), opt.search, opt.mode)+
+					" THEN "+d.occurrences(d.quote(col), opt.search)+" ELSE 0 END)")
+			}
 		}
 	}
+	where := b.where(opt)
+	return d.selectQuery(strings.Join(aggregates, ", "), opt.table, where, "", 0, 0)
+}
 
-	s := b.b.String()
-	b.b.Reset()
-	return s
+func (b *queryBuilder) where(opt queryOptions) string {
+	return "WHERE " + b.matching(opt) + " "
+}
+
+// matching returns a condition matching the rows of opt: those with a value
+// in any of the columns with everyRow, or else those matching any of the pairs.
+func (b *queryBuilder) matching(opt queryOptions) string {
+	if opt.everyRow {
+		return b.notNull(opt.columns)
+	}
+	return b.matchAny(opt.columns, opt.patterns())
 }
 
-func (b *queryBuilder) where(columns []string, search string, mode Mode) {
-	b.b.WriteString("WHERE ")
+// matchAny returns a condition matching rows where any of the columns matches
+// the search of any of the pairs.
+func (b *queryBuilder) matchAny(columns []string, pairs []Pair) string {
+	d := b.dialect()
 	for i, col := range columns {
-		fmt.Fprintf(&b.b, "`%s` ", col)
-
-		switch mode {
-		case Equals:
-			b.b.WriteString(querySprintf("= '%s' ", search))
-		case Contains:
-			escapedSearch := strings.Replace(search, `%`, `\%`, -1)
-			b.b.WriteString("LIKE BINARY '%" + querySprintf("%s", escapedSearch) + "%' ")
-		case Like:
-			b.b.WriteString(querySprintf("LIKE BINARY '%s' ", search))
-		case Regexp:
-			b.b.WriteString(querySprintf("REGEXP '%s' ", search))
+		for j, p := range pairs {
+			b.b.WriteString(d.match(d.quote(col), p.Search, p.Mode))
+
+			if j < len(pairs)-1 {
+				b.b.WriteString(" OR ")
+			}
 		}
 
 		if i < len(columns)-1 {
-			b.b.WriteString("OR ")
+			b.b.WriteString(" OR ")
+		}
+	}
+	return b.flush()
+}
+
+// notNull returns a condition matching rows where any of the columns isn't null.
+func (b *queryBuilder) notNull(columns []string) string {
+	d := b.dialect()
+	conds := make([]string, len(columns))
+	for i, col := range columns {
+		conds[i] = d.quote(col) + " IS NOT NULL"
+	}
+	return strings.Join(conds, " OR ")
+}
+
+// selectKeyed builds a query selecting the key columns followed by opt.columns
+// of rows matching opt.search, in key order. If after isn't nil, rows
+// start after the row with these key values.
+func (b *queryBuilder) selectKeyed(opt queryOptions, key, after []string) (string, []interface{}) {
+	d := b.dialect()
+	columns := make([]string, 0, len(key)+len(opt.columns))
+	for _, col := range append(key[:len(key):len(key)], opt.columns...) {
+		columns = append(columns, d.quote(col))
+	}
+	return b.selectAfter(strings.Join(columns, ", "), opt, key, after)
+}
+
+// selectPage is like selectKeyed, but selects every column of the table.
+func (b *queryBuilder) selectPage(opt queryOptions, key, after []string) (string, []interface{}) {
+	return b.selectAfter("*", opt, key, after)
+}
+
+func (b *queryBuilder) selectAfter(columns string, opt queryOptions, key, after []string) (string, []interface{}) {
+	d := b.dialect()
+	args := &queryArgs{d: d}
+	match := b.matching(opt)
+	where := "WHERE (" + match + ") "
+	if after != nil {
+		where = "WHERE " + b.keyAfter(args, key, after) + " AND (" + match + ") "
+	}
+	return d.selectQuery(columns, opt.table, where, b.orderBy(key), 0, opt.limit), args.args
+}
+
+// keyAt builds a query selecting the key of the row offset rows into the table in key order,
+// counting from the row after the one with the key values after if it isn't nil.
+func (b *queryBuilder) keyAt(table string, key, after []string, offset int) (string, []interface{}) {
+	d := b.dialect()
+	args := &queryArgs{d: d}
+	columns := make([]string, len(key))
+	for i, col := range key {
+		columns[i] = d.quote(col)
+	}
+	where := ""
+	if after != nil {
+		where = "WHERE " + b.keyAfter(args, key, after) + " "
+	}
+	return d.selectQuery(strings.Join(columns, ", "), table, where, b.orderBy(key), offset, 1), args.args
+}
+
+// updateRange builds a query making the replacement in the rows matching opt.search
+// with a key greater than lo and up to hi. A nil lo or hi leaves the range open on that side.
+func (b *queryBuilder) updateRange(opt queryOptions, key, lo, hi []string) (string, []interface{}) {
+	d := b.dialect()
+	args := &queryArgs{d: d}
+	set := b.set(opt.columns, opt.patterns())
+	var where []string
+	if lo != nil {
+		where = append(where, b.keyAfter(args, key, lo))
+	}
+	if hi != nil {
+		where = append(where, b.keyUpTo(args, key, hi))
+	}
+	where = append(where, "("+b.matchAny(opt.columns, opt.patterns())+")")
+	return d.updateQuery(opt.table, set, "WHERE "+strings.Join(where, " AND ")+" ", 0), args.args
+}
+
+// keyAfter returns a condition matching rows with a key greater than values.
+func (b *queryBuilder) keyAfter(args *queryArgs, key, values []string) string {
+	return b.keyCompare(args, key, values, ">", ">")
+}
+
+// keyUpTo returns a condition matching rows with a key less than or equal to values.
+func (b *queryBuilder) keyUpTo(args *queryArgs, key, values []string) string {
+	return b.keyCompare(args, key, values, "<", "<=")
+}
+
+// keyCompare compares the key with values lexicographically, using op for all
+// but the last column and lastOp for the last one.
+func (b *queryBuilder) keyCompare(args *queryArgs, key, values []string, op, lastOp string) string {
+	// Row value comparisons aren't supported everywhere, so (a, b) > (x, y)
+	// is written as a > x OR (a = x AND b > y).
+	d := b.dialect()
+	var or []string
+	for i := range key {
+		var and []string
+		for j := 0; j < i; j++ {
+			and = append(and, d.quote(key[j])+" = "+args.add(values[j]))
+		}
+		if i < len(key)-1 {
+			and = append(and, d.quote(key[i])+" "+op+" "+args.add(values[i]))
+		} else {
+			and = append(and, d.quote(key[i])+" "+lastOp+" "+args.add(values[i]))
+		}
+		if len(and) > 1 {
+			or = append(or, "("+strings.Join(and, " AND ")+")")
+		} else {
+			or = append(or, and[0])
+		}
+	}
+	if len(or) == 1 {
+		return or[0]
+	}
+	return "(" + strings.Join(or, " OR ") + ")"
+}
+
+func (b *queryBuilder) orderBy(key []string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	d := b.dialect()
+	quoted := make([]string, len(key))
+	for i, col := range key {
+		quoted[i] = d.quote(col)
+	}
+	return "ORDER BY " + strings.Join(quoted, ", ") + " "
+}
+
+// updateRow builds a query that applies changes to the row with the given key values,
+// as long as the changed columns still hold their old values. Without a key, every
+// row holding the old values is updated.
+func (b *queryBuilder) updateRow(table string, key, keyValues []string, changes []cellChange) (string, []interface{}) {
+	d := b.dialect()
+	args := &queryArgs{d: d}
+
+	set := make([]string, len(changes))
+	for i, c := range changes {
+		set[i] = d.quote(c.column) + " = " + args.add(c.new)
+	}
+	var where []string
+	for i, col := range key {
+		where = append(where, d.quote(col)+" = "+args.add(keyValues[i]))
+	}
+	for _, c := range changes {
+		same := d.same(d.quote(c.column), args.add(c.old))
+		if c.orNull {
+			same = "(" + same + " OR " + d.quote(c.column) + " IS NULL)"
 		}
+		where = append(where, same)
 	}
+	return d.updateQuery(table,
+		"SET "+strings.Join(set, ", ")+" ",
+		"WHERE "+strings.Join(where, " AND ")+" ",
+		0), args.args
 }
 
-func (b *queryBuilder) set(columns []string, search, replace string, mode Mode) {
+// set returns a SET clause making the replacements of the pairs in each of the columns,
+// with the replacement of each pair nested in that of the next.
+func (b *queryBuilder) set(columns []string, pairs []Pair) string {
+	d := b.dialect()
 	b.b.WriteString("SET ")
 	for i, col := range columns {
-		fmt.Fprintf(&b.b, "`%s` = ", col)
-
-		switch mode {
-		case Equals:
-			b.b.WriteString(querySprintf("'%s' ", replace))
-		case Contains:
-			b.b.WriteString(querySprintf("REPLACE(`%s`, '%s', '%s') ", col, search, replace))
-		case Like:
-			panic("queryBuilder.set: update queries don't support Like")
-		case Regexp:
-			b.b.WriteString(querySprintf("REGEXP_REPLACE(`%s`, '%s', '%s') ", col, search, replace))
+		expr := d.quote(col)
+		for j := 0; j < len(pairs); j++ {
+			p := pairs[j]
+			switch {
+			case p.Mode == Like:
+				panic("queryBuilder.set: update queries don't support Like")
+			case p.Mode == Equals && len(pairs) > 1:
+				// Only the values equal to a search are replaced, while with
+				// a single pair the WHERE clause leaves out any others.
+				// A run of Equals pairs is made in a single CASE, which holds
+				// the expression of the pairs before it twice.
+				run := equalsRun(pairs[j:])
+				expr = "CASE " + d.text(expr) + equalsWhens(d, run) + " ELSE " + expr + " END"
+				j += len(run) - 1
+			default:
+				expr = d.replace(expr, p.Search, p.Replace, p.Mode)
+			}
 		}
+		fmt.Fprintf(&b.b, "%s = %s ", d.quote(col), expr)
 
 		if i < len(columns)-1 {
 			b.b.WriteString(", ")
 		}
 	}
+	return b.flush()
+}
+
+// maxEqualsRuns is the number of runs of Equals pairs past which a replacement
+// is made in Go, since each run doubles the length of the SET expression.
+const maxEqualsRuns = 4
+
+// equalsRun returns the Equals pairs at the start of pairs.
+func equalsRun(pairs []Pair) []Pair {
+	n := 0
+	for n < len(pairs) && pairs[n].Mode == Equals {
+		n++
+	}
+	return pairs[:n]
 }
 
-func querySprintf(format string, args ...interface{}) string {
-	for i := range args {
-		args[i] = queryEscape(fmt.Sprint(args[i]))
+// equalsRuns returns the number of runs of Equals pairs that set makes in
+// a CASE expression.
+func equalsRuns(pairs []Pair) int {
+	if len(pairs) < 2 {
+		return 0
+	}
+	runs := 0
+	for i, p := range pairs {
+		if p.Mode == Equals && (i == 0 || pairs[i-1].Mode != Equals) {
+			runs++
+		}
 	}
-	return fmt.Sprintf(format, args...)
+	return runs
+}
+
+// equalsWhens returns the WHEN clauses of a CASE expression making a run of
+// Equals pairs, each applied to the result of the one before it.
+func equalsWhens(d dialect, run []Pair) string {
+	var b strings.Builder
+	seen := map[string]bool{}
+	for _, p := range run {
+		if seen[p.Search] {
+			continue
+		}
+		seen[p.Search] = true
+		v := p.Search
+		for _, q := range run {
+			if v == q.Search {
+				v = q.Replace
+			}
+		}
+		b.WriteString(" WHEN " + d.literal(p.Search) + " THEN " + d.literal(v))
+	}
+	return b.String()
+}
+
+// queryArgs collects the arguments of a query.
+type queryArgs struct {
+	d    dialect
+	args []interface{}
+}
+
+// add adds an argument and returns its placeholder.
+func (a *queryArgs) add(v interface{}) string {
+	a.args = append(a.args, v)
+	return a.d.placeholder(len(a.args))
+}
+
+// flush returns the string built so far and resets the builder.
+func (b *queryBuilder) flush() string {
+	s := b.b.String()
+	b.b.Reset()
+	return s
 }
 
 func queryEscape(sql string) string {
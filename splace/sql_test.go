@@ -3,6 +3,8 @@ package splace
 import (
 	"strings"
 	"testing"
+
+	"github.com/zippoxer/splace/splace/querier"
 )
 
 var queryBuilderTests = []struct {
@@ -38,7 +40,43 @@ var queryBuilderTests = []struct {
 			mode:    Like,
 			search:  "%Dvid%",
 		},
-		"SELECT * FROM `people` WHERE `name` LIKE '%Dvid%' OR `address` LIKE '%Dvid%'",
+		"SELECT * FROM `people` WHERE `name` LIKE BINARY '%Dvid%' OR `address` LIKE BINARY '%Dvid%'",
+	},
+	{
+		queryOptions{
+			table:   "people",
+			columns: []string{"name"},
+			update:  true,
+			pairs: []Pair{
+				{Search: "Dvid", Replace: "David", Mode: Contains},
+				{Search: "Tel Aviv", Replace: "TLV", Mode: Equals},
+			},
+		},
+		// Each pair is applied to the result of the one before it.
+		"UPDATE `people` SET `name` = CASE REPLACE(`name`, 'Dvid', 'David') WHEN 'Tel Aviv' THEN 'TLV' ELSE REPLACE(`name`, 'Dvid', 'David') END WHERE `name` LIKE BINARY '%Dvid%' OR `name` = 'Tel Aviv'",
+	},
+	{
+		queryOptions{
+			table:   "people",
+			columns: []string{"name"},
+			update:  true,
+			pairs: []Pair{
+				{Search: "Dvid", Replace: "David", Mode: Equals},
+				{Search: "David", Replace: "Dave", Mode: Equals},
+				{Search: "Dave", Replace: "D.", Mode: Contains},
+			},
+		},
+		// A run of Equals pairs is made in a single CASE.
+		"UPDATE `people` SET `name` = REPLACE(CASE `name` WHEN 'Dvid' THEN 'Dave' WHEN 'David' THEN 'Dave' ELSE `name` END, 'Dave', 'D.') WHERE `name` = 'Dvid' OR `name` = 'David' OR `name` LIKE BINARY '%Dave%'",
+	},
+	{
+		queryOptions{
+			table:    "people",
+			columns:  []string{"name", "address"},
+			everyRow: true,
+		},
+		// Replacements matched in Go are made in every row with a value.
+		"SELECT * FROM `people` WHERE `name` IS NOT NULL OR `address` IS NOT NULL",
 	},
 }
 
@@ -51,3 +89,230 @@ func TestQueryBuilder(t *testing.T) {
 		}
 	}
 }
+
+var dialectTests = []struct {
+	engine querier.Engine
+	in     queryOptions
+	out    string
+}{
+	{
+		querier.PostgreSQL,
+		queryOptions{
+			table:   "people",
+			columns: []string{"name", "address"},
+			mode:    Contains,
+			search:  "O'Neil 100%",
+			offset:  2000,
+			limit:   1000,
+		},
+		`SELECT * FROM "people" WHERE "name"::text LIKE '%O''Neil 100\%%' OR "address"::text LIKE '%O''Neil 100\%%' LIMIT 1000 OFFSET 2000`,
+	},
+	{
+		querier.PostgreSQL,
+		queryOptions{
+			table:   "crm.people",
+			columns: []string{"name"},
+			mode:    Regexp,
+			search:  "^D(v)id$",
+			update:  true,
+			replace: "Da$1id",
+		},
+		`UPDATE "crm"."people" SET "name" = regexp_replace("name", '^D(v)id$', 'Da$1id', 'g') WHERE "name"::text ~ '^D(v)id$'`,
+	},
+	{
+		querier.PostgreSQL,
+		queryOptions{
+			table:   "people",
+			columns: []string{"name"},
+			mode:    Contains,
+			search:  "Dvid",
+			limit:   1000,
+			update:  true,
+			replace: "David",
+		},
+		`UPDATE "people" SET "name" = replace("name", 'Dvid', 'David') WHERE ctid IN (SELECT ctid FROM "people" WHERE "name"::text LIKE '%Dvid%' LIMIT 1000)`,
+	},
+	{
+		querier.SQLite,
+		queryOptions{
+			table:   "people",
+			columns: []string{"name", "address"},
+			mode:    Contains,
+			search:  "100%",
+			offset:  2000,
+		},
+		`SELECT * FROM "people" WHERE instr("name", '100%') > 0 OR instr("address", '100%') > 0 LIMIT -1 OFFSET 2000`,
+	},
+	{
+		querier.SQLite,
+		queryOptions{
+			table:   "people",
+			columns: []string{"name"},
+			mode:    Contains,
+			search:  "Dvid",
+			limit:   1000,
+			update:  true,
+			replace: "David",
+		},
+		`UPDATE "people" SET "name" = CASE WHEN instr("name", 'Dvid') > 0 THEN replace("name", 'Dvid', 'David') ELSE "name" END WHERE rowid IN (SELECT rowid FROM "people" WHERE instr("name", 'Dvid') > 0 LIMIT 1000)`,
+	},
+	{
+		querier.SQLServer,
+		queryOptions{
+			table:   "sales.people",
+			columns: []string{"name"},
+			mode:    Contains,
+			search:  "[100%]",
+			offset:  2000,
+			limit:   1000,
+		},
+		`SELECT * FROM [sales].[people] WHERE CAST([name] AS nvarchar(max)) COLLATE Latin1_General_BIN LIKE N'%\[100\%]%' ESCAPE '\' ORDER BY (SELECT NULL) OFFSET 2000 ROWS FETCH NEXT 1000 ROWS ONLY`,
+	},
+	{
+		querier.SQLServer,
+		queryOptions{
+			table:   "people",
+			columns: []string{"name"},
+			mode:    Contains,
+			search:  "Dvid",
+			limit:   1000,
+			update:  true,
+			replace: "David",
+		},
+		`UPDATE TOP (1000) [people] SET [name] = REPLACE(CAST([name] AS nvarchar(max)) COLLATE Latin1_General_BIN, N'Dvid', N'David') WHERE CAST([name] AS nvarchar(max)) COLLATE Latin1_General_BIN LIKE N'%Dvid%' ESCAPE '\'`,
+	},
+}
+
+func TestDialects(t *testing.T) {
+	for i, test := range dialectTests {
+		result := newQueryBuilder(test.engine).build(test.in)
+		if strings.TrimSpace(result) != strings.TrimSpace(test.out) {
+			t.Errorf("failed test %d: expected %q, got %q", i, test.out, result)
+		}
+	}
+}
+
+func TestKeyedQueries(t *testing.T) {
+	qb := newQueryBuilder(querier.PostgreSQL)
+
+	query, args := qb.selectKeyed(queryOptions{
+		table:   "postmeta",
+		columns: []string{"meta_value"},
+		mode:    Contains,
+		search:  "old.com",
+		limit:   100,
+	}, []string{"post_id", "meta_id"}, []string{"7", "12"})
+	expected := `SELECT "post_id", "meta_id", "meta_value" FROM "postmeta" ` +
+		`WHERE ("post_id" > $1 OR ("post_id" = $2 AND "meta_id" > $3)) AND ("meta_value"::text LIKE '%old.com%') ` +
+		`ORDER BY "post_id", "meta_id" LIMIT 100`
+	if strings.TrimSpace(query) != expected || len(args) != 3 {
+		t.Errorf("selectKeyed: expected %q, got %q %v", expected, query, args)
+	}
+
+	query, args = newQueryBuilder(querier.MySQL).selectPage(queryOptions{
+		table:   "wp_posts",
+		columns: []string{"post_content"},
+		mode:    Contains,
+		search:  "old.com",
+		limit:   100,
+	}, []string{"ID"}, []string{"42"})
+	expected = "SELECT * FROM `wp_posts` WHERE `ID` > ? AND (`post_content` LIKE BINARY '%old.com%') " +
+		"ORDER BY `ID` LIMIT 100"
+	if strings.TrimSpace(query) != expected || len(args) != 1 || args[0] != "42" {
+		t.Errorf("selectPage: expected %q, got %q %v", expected, query, args)
+	}
+
+	query, args = qb.updateRange(queryOptions{
+		table:   "postmeta",
+		columns: []string{"meta_value"},
+		mode:    Contains,
+		search:  "old.com",
+		replace: "new.com",
+	}, []string{"post_id", "meta_id"}, []string{"7", "12"}, []string{"9", "3"})
+	expected = `UPDATE "postmeta" SET "meta_value" = replace("meta_value", 'old.com', 'new.com') ` +
+		`WHERE ("post_id" > $1 OR ("post_id" = $2 AND "meta_id" > $3)) ` +
+		`AND ("post_id" < $4 OR ("post_id" = $5 AND "meta_id" <= $6)) ` +
+		`AND ("meta_value"::text LIKE '%old.com%')`
+	if strings.TrimSpace(query) != expected || len(args) != 6 {
+		t.Errorf("updateRange: expected %q, got %q %v", expected, query, args)
+	}
+
+	query, args = qb.updateRow("postmeta", []string{"meta_id"}, []string{"12"}, []cellChange{
+		{column: "meta_value", old: "http://old.com", new: "http://new.com"},
+	})
+	expected = `UPDATE "postmeta" SET "meta_value" = $1 WHERE "meta_id" = $2 AND "meta_value" = $3`
+	if strings.TrimSpace(query) != expected || len(args) != 3 || args[0] != "http://new.com" {
+		t.Errorf("updateRow: expected %q, got %q %v", expected, query, args)
+	}
+
+	query, _ = qb.updateRow("postmeta", []string{"meta_id"}, []string{"12"}, []cellChange{
+		{column: "meta_value", old: "", new: "http://new.com", orNull: true},
+	})
+	expected = `UPDATE "postmeta" SET "meta_value" = $1 WHERE "meta_id" = $2 AND ("meta_value" = $3 OR "meta_value" IS NULL)`
+	if strings.TrimSpace(query) != expected {
+		t.Errorf("updateRow: expected %q, got %q", expected, query)
+	}
+
+	query, args = qb.selectRow("postmeta", []string{"meta_key", "meta_value"}, []string{"post_id", "meta_id"}, []string{"7", "12"})
+	expected = `SELECT "meta_key", "meta_value" FROM "postmeta" WHERE "post_id" = $1 AND "meta_id" = $2`
+	if strings.TrimSpace(query) != expected || len(args) != 2 || args[1] != "12" {
+		t.Errorf("selectRow: expected %q, got %q %v", expected, query, args)
+	}
+}
+
+func TestCountQuery(t *testing.T) {
+	qb := newQueryBuilder(querier.MySQL)
+	opt := queryOptions{
+		table:   "posts",
+		columns: []string{"title", "body"},
+		mode:    Contains,
+		search:  "old",
+	}
+	expected := "SELECT COUNT(*), " +
+		"SUM(CASE WHEN `title` LIKE BINARY '%old%' THEN 1 ELSE 0 END), " +
+		"SUM(CASE WHEN `body` LIKE BINARY '%old%' THEN 1 ELSE 0 END) " +
+		"FROM `posts` WHERE `title` LIKE BINARY '%old%' OR `body` LIKE BINARY '%old%' "
+	if query := qb.count(opt, false); query != expected {
+		t.Errorf("expected %q, got %q", expected, query)
+	}
+
+	opt.columns = opt.columns[:1]
+	expected = "SELECT COUNT(*), " +
+		"SUM(CASE WHEN `title` LIKE BINARY '%old%' THEN 1 ELSE 0 END), " +
+		"SUM(CASE WHEN `title` LIKE BINARY '%old%' THEN " +
+		"(CHAR_LENGTH(`title`) - CHAR_LENGTH(REPLACE(`title`, 'old', ''))) DIV CHAR_LENGTH('old') ELSE 0 END) " +
+		"FROM `posts` WHERE `title` LIKE BINARY '%old%' "
+	if query := qb.count(opt, true); query != expected {
+		t.Errorf("expected %q, got %q", expected, query)
+	}
+}
+
+func TestSchemaQueries(t *testing.T) {
+	query := mysqlDialect{}.schemaTablesQuery([]string{"tenant_1", "tenant_'2"})
+	expected := "SELECT c.TABLE_SCHEMA, c.TABLE_NAME, c.COLUMN_NAME, c.COLUMN_TYPE, "
+	where := "WHERE c.TABLE_SCHEMA IN ('tenant_1', 'tenant_\\'2') "
+	if !strings.HasPrefix(query, expected) || !strings.Contains(query, where) {
+		t.Errorf("schemaTablesQuery: expected %q and %q, got %q", expected, where, query)
+	}
+
+	query = newQueryBuilder(querier.MySQL).build(queryOptions{
+		table:   "tenant_1.wp_options",
+		columns: []string{"option_value"},
+		mode:    Equals,
+		search:  "old.com",
+	})
+	expected = "SELECT * FROM `tenant_1`.`wp_options` WHERE `option_value` = 'old.com'"
+	if strings.TrimSpace(query) != expected {
+		t.Errorf("build: expected %q, got %q", expected, query)
+	}
+
+	for _, table := range []string{"posts", "main.posts"} {
+		query = sqliteDialect{}.keyQuery(table)
+		if !strings.Contains(query, "pragma_table_info('posts', 'main')") {
+			t.Errorf("keyQuery(%q): expected the table info of main.posts, got %q", table, query)
+		}
+	}
+	if schema := tableSchema("tenant_1.wp_options"); schema != "tenant_1" {
+		t.Errorf("tableSchema: expected tenant_1, got %q", schema)
+	}
+}
@@ -4,6 +4,10 @@ export const SEARCH_MODES = {
   3: 'REGEX'
 }
 
+// SNIPPET_CONTEXT is the number of characters around each match sent in
+// search results, whose whole values are fetched on demand.
+export const SNIPPET_CONTEXT = 40
+
 export const DB_DRIVERS = {
   direct: 'Standard',
   php: 'PHP'
@@ -13,5 +17,6 @@ export const DB_ENGINES = {
   mysql: 'MySQL',
   postgres: 'PostgreSQL',
   sqlserver: 'SQL Server',
-  oracle: 'Oracle'
+  oracle: 'Oracle',
+  sqlite3: 'SQLite'
 }
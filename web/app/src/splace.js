@@ -5,44 +5,241 @@ export default class Splace extends EventEmitter {
   constructor () {
     super()
     this.url = window.apiURL || 'http://localhost:30993'
+    this.token = window.apiToken || ''
   }
 
   connect (params) {
     return this._request('POST', '/connect', params)
+      .then(data => {
+        this.session = data.Session
+        return data
+      })
+  }
+
+  // schemas lists the schemas of the server, which connect takes
+  // as params.Schemas to work across them.
+  schemas () {
+    return this._request('GET', '/schemas')
+  }
+
+  disconnect () {
+    let promise = this._request('POST', '/disconnect')
+    this.session = null
+    return promise
+  }
+
+  dumpURL () {
+    return this._url('/dump')
+  }
+
+  phpProxyURL () {
+    return this._url('/download-php-proxy')
   }
 
   search (options) {
-    let src = new EventSource(this.url + '/search?options=' +
-      encodeURIComponent(JSON.stringify(options)),
-    { retry: null })
+    let src = this._jobSource('/search', options)
     src.cancel = () => {
       src.close()
+      if (src.jobID) {
+        this.cancel(src.jobID)
+      }
       src.dispatchEvent(new Event('cancel'))
     }
     return src
   }
 
-  replace (options) {
-    return new EventSource(this.url + '/replace?options=' +
+  // replace starts a replace job and resolves to the EventSource of its events.
+  // With backup, the tables are dumped first and can be restored with restore.
+  // rows, if set, restricts the job to rows ticked in search results, as
+  // { table: [{ Key: [...], Values: { column: value } }] }.
+  // options.Pairs, if set, is a list of { Search, Replace, Mode } applied
+  // in order in place of options.Search and options.Replace.
+  replace (options, journal, backup, rows) {
+    return this._request('POST', '/jobs', {
+      Kind: 'replace',
+      Options: options,
+      Journal: !!journal,
+      Backup: !!backup,
+      Rows: rows || null
+    })
+      .then(job => this.attach(job.ID))
+  }
+
+  // replaceMapping starts a replace job, or a preview job with preview, of
+  // the pairs in the text of a CSV mapping, or TSV with tsv, such as an
+  // uploaded file, skipping its first line with header. The job sends the
+  // hits of each pair in a "hits" event before it's done, unless it fails.
+  replaceMapping (options, mapping, tsv, header, preview, journal, backup) {
+    return this._request('POST', '/jobs', {
+      Kind: preview ? 'preview' : 'replace',
+      Options: options,
+      Journal: !!journal,
+      Backup: !!backup,
+      Mapping: mapping,
+      MappingTSV: !!tsv,
+      MappingHeader: !!header
+    })
+      .then(job => this.attach(job.ID))
+  }
+
+  // updateCell edits a cell of the row with the given key values, as long as
+  // it still holds old, and rejects with a 409 if it has changed since. With
+  // journal, the edit is recorded in a journal of its own, and the promise
+  // resolves to an object with the ID of the journal.
+  updateCell (table, column, key, keyValues, old, value, journal) {
+    return this._request('POST', '/cell', {
+      Table: table,
+      Column: column,
+      Key: key,
+      KeyValues: keyValues,
+      Old: old,
+      Value: value,
+      Journal: !!journal
+    })
+  }
+
+  // value fetches the whole value of a column in the row with the given
+  // key columns and values, for matches of a search with Snippets.
+  value (table, column, key, values) {
+    let params = new URLSearchParams({ table: table, column: column })
+    key.forEach((k, i) => {
+      params.append('key', k)
+      params.append('value', values[i])
+    })
+    return this._request('GET', '/value?' + params.toString())
+      .then(data => data.Value)
+  }
+
+  preview (options) {
+    return this._jobSource('/preview', options)
+  }
+
+  jobs () {
+    return this._request('GET', '/jobs')
+  }
+
+  job (id) {
+    return this._request('GET', '/jobs/' + id)
+  }
+
+  // attach reattaches to the events of a running job, replaying
+  // those following lastEventId.
+  attach (id, lastEventId) {
+    let src = new EventSource(this._url('/jobs/' + id + '/events') +
+      (lastEventId ? '&lastEventId=' + encodeURIComponent(lastEventId) : ''))
+    src.jobID = id
+    return src
+  }
+
+  _jobSource (path, options) {
+    let src = new EventSource(this._url(path) + '&options=' +
       encodeURIComponent(JSON.stringify(options)),
     { retry: null })
+    src.addEventListener('job', e => {
+      src.jobID = JSON.parse(e.data).ID
+    })
+    return src
+  }
+
+  journals () {
+    return this._request('GET', '/journals')
+  }
+
+  rollback (id) {
+    return this._request('POST', '/rollback', { ID: id })
   }
 
-  cancel () {
-    return this._request('POST', '/cancel')
+  backups () {
+    return this._request('GET', '/backups')
+  }
+
+  restore (id) {
+    return this._request('POST', '/restore', { ID: id })
+  }
+
+  // upload restores an SQL dump, plain or gzipped, calling onProgress with
+  // the Read and Size of each progress event. EventSource can't POST,
+  // so the events are read from the response as it arrives.
+  upload (file, onProgress) {
+    return fetch(this.url + '/upload', {
+      method: 'POST',
+      headers: this._headers(),
+      body: file
+    }).then(resp => {
+      if (!resp.ok) {
+        return resp.json().then(data => {
+          this.emit('error', data)
+          throw new Error(data.Error)
+        })
+      }
+      let reader = resp.body.getReader()
+      let decoder = new TextDecoder()
+      let buffer = ''
+      let read = () => reader.read().then(({ done, value }) => {
+        if (done) {
+          throw new Error('upload interrupted')
+        }
+        buffer += decoder.decode(value, { stream: true })
+        let events = buffer.split('\n\n')
+        buffer = events.pop()
+        for (let ev of events) {
+          let name = ''
+          let data = ''
+          ev.split('\n').forEach(line => {
+            if (line.startsWith('event: ')) {
+              name = line.slice('event: '.length)
+            } else if (line.startsWith('data: ')) {
+              data += line.slice('data: '.length)
+            }
+          })
+          let msg = JSON.parse(data)
+          if (name === 'done') {
+            if (msg.Error) {
+              throw new Error(msg.Error)
+            }
+            return msg
+          }
+          if (name === 'progress' && onProgress) {
+            onProgress(msg)
+          }
+        }
+        return read()
+      })
+      return read()
+    })
+  }
+
+  cancel (id) {
+    return this._request('POST', '/cancel', { ID: id })
   }
 
   _request (method, path, data) {
     let promise = axios({
       method: method,
       data: data,
-      url: this.url + path
+      url: this.url + path,
+      headers: this._headers()
     })
       .then(resp => resp.data)
     promise.catch(e => this._handleError(e))
     return promise
   }
 
+  _headers () {
+    let headers = { 'X-Splace-Token': this.token }
+    if (this.session) {
+      headers['X-Splace-Session'] = this.session
+    }
+    return headers
+  }
+
+  // _url returns the URL of a GET request that can't set headers,
+  // such as EventSource and download links.
+  _url (path) {
+    return this.url + path + '?token=' + encodeURIComponent(this.token) +
+      '&session=' + encodeURIComponent(this.session || '')
+  }
+
   _handleError (e) {
     console.error(e)
     this.emit('error', e.response.data, e)
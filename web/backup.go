@@ -0,0 +1,201 @@
+package web
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zippoxer/splace/web/sse"
+
+	"github.com/labstack/echo"
+)
+
+const backupExt = ".sql.gz"
+
+// backupIDs are named after the database and the time of the backup,
+// such as wordpress--2006-01-02--15-04-05-1a2b3c4d.
+var backupID = regexp.MustCompile(`^([A-Za-z0-9_]*)--\d{4}-\d{2}-\d{2}--\d{2}-\d{2}-\d{2}-[0-9a-f]{8}$`)
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func (s *Server) backupDir() (string, error) {
+	dir := s.opt.BackupDir
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cache, "splace", "backups")
+	}
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// createBackup creates the backup file of a replace job in a database.
+func (s *Server) createBackup(database string) (id string, path string, f *os.File, err error) {
+	dir, err := s.backupDir()
+	if err != nil {
+		return "", "", nil, err
+	}
+	id = unsafeNameChars.ReplaceAllString(database, "_") + "--" +
+		time.Now().Format("2006-01-02--15-04-05") + "-" + uuid.NewV4().String()[:8]
+	path = filepath.Join(dir, id+backupExt)
+	f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	return id, path, f, err
+}
+
+type backupInfo struct {
+	ID       string
+	Database string
+	Created  time.Time
+	Size     int64
+}
+
+func (s *Server) backups(c echo.Context) error {
+	dir, err := s.backupDir()
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	resp := []backupInfo{}
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), backupExt)
+		m := backupID.FindStringSubmatch(id)
+		if m == nil || !strings.HasSuffix(f.Name(), backupExt) {
+			continue
+		}
+		resp = append(resp, backupInfo{
+			ID:       id,
+			Database: m[1],
+			Created:  f.ModTime(),
+			Size:     f.Size(),
+		})
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Created.After(resp[j].Created)
+	})
+	return c.JSON(http.StatusOK, resp)
+}
+
+type restoreReq struct {
+	ID string
+}
+
+// restore restores the tables of a backup to the database of the session.
+func (s *Server) restore(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	var req restoreReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	// The ID pattern also keeps IDs from escaping the backup directory.
+	m := backupID.FindStringSubmatch(req.ID)
+	if m == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid backup ID")
+	}
+	if database := unsafeNameChars.ReplaceAllString(ss.db.Config().Database, "_"); m[1] != database {
+		return echo.NewHTTPError(http.StatusConflict, "the backup is of another database")
+	}
+	dir, err := s.backupDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(dir, req.ID+backupExt))
+	if os.IsNotExist(err) {
+		return echo.NewHTTPError(http.StatusNotFound, "backup not found")
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := ss.db.Restore(c.Request().Context(), f); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// progressInterval is how often the progress of an upload is sent.
+const progressInterval = 250 * time.Millisecond
+
+type uploadProgress struct {
+	// Read is the number of bytes of the dump restored so far, out of Size.
+	Read int64
+	Size int64
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
+
+// upload restores the SQL dump in the request body, plain or gzipped, to the
+// database of the session, and streams the progress of the restore.
+func (s *Server) upload(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+
+	// The upload is received before the response begins, since
+	// HTTP/1 clients don't expect a response while uploading.
+	f, err := ioutil.TempFile("", "splace-upload-*.sql")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, c.Request().Body)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	stream := sse.Open(c.Response().Writer)
+	defer stream.Close()
+
+	r := &countingReader{r: f}
+	done := make(chan error, 1)
+	go func() {
+		done <- ss.db.Restore(c.Request().Context(), r)
+	}()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ss.touch()
+			stream.Send("progress", uploadProgress{Read: r.count(), Size: size})
+		case err := <-done:
+			stream.Send("progress", uploadProgress{Read: r.count(), Size: size})
+			sendDone(stream, err, nil)
+			return stream.Close()
+		}
+	}
+}
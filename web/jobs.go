@@ -0,0 +1,517 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zippoxer/splace/splace"
+	"github.com/zippoxer/splace/web/sse"
+
+	"github.com/labstack/echo"
+)
+
+// maxFinishedJobs is the number of finished jobs kept around for
+// clients to check on, along with their events.
+const maxFinishedJobs = 20
+
+// maxReplayBytes bounds the row events a running job keeps for clients to
+// replay, and maxFinishedReplayBytes those a finished job keeps. Beyond them,
+// the data of the oldest row events is dropped and replays skip them.
+const (
+	maxReplayBytes         = 16 << 20
+	maxFinishedReplayBytes = 1 << 20
+)
+
+type jobStatus string
+
+const (
+	jobRunning  jobStatus = "running"
+	jobDone     jobStatus = "done"
+	jobFailed   jobStatus = "failed"
+	jobCanceled jobStatus = "canceled"
+)
+
+type jobEvent struct {
+	name string
+	data interface{}
+
+	// size is the size of the data of a row event, which is kept
+	// encoded, or 0 for other events.
+	size    int
+	dropped bool
+}
+
+type jobProgress struct {
+	// Tables is the number of tables started so far.
+	Tables int
+
+	// Rows is the number of rows found by a search or preview,
+	// or updated by a replace.
+	Rows int
+}
+
+// job is a search, replace or preview running in the background, independently
+// of the requests following it. It records the events it sends, so clients can
+// reattach and replay them.
+type job struct {
+	id      string
+	kind    string
+	session *session
+	created time.Time
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	status   jobStatus
+	err      error
+	finished time.Time
+	progress jobProgress
+	backup   string
+	events   []jobEvent
+
+	// rowBytes is the size of the row events kept, dropped the number of
+	// row events whose data was dropped, and trimmed the number of events
+	// that were checked for dropping.
+	rowBytes int
+	dropped  int
+	trimmed  int
+
+	// changed is closed and replaced whenever an event is sent or the job finishes.
+	changed chan struct{}
+}
+
+// Send records an event of the job and updates its progress.
+func (j *job) Send(ev string, data interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch ev {
+	case "backup":
+		if b, ok := data.(backupEvent); ok {
+			j.backup = b.Path
+		}
+	case "table":
+		j.progress.Tables++
+	case "rows", "affected_rows", "matches":
+		// Rows are sent as [table, count, ...] or [table, []row].
+		if v, ok := data.([]interface{}); ok && len(v) >= 2 {
+			switch n := v[1].(type) {
+			case int:
+				j.progress.Rows += n
+			case []splace.PreviewRow:
+				j.progress.Rows += len(n)
+			case []splace.MatchRow:
+				j.progress.Rows += len(n)
+			}
+		}
+	}
+	e := jobEvent{name: ev, data: data}
+	if ev == "rows" || ev == "matches" {
+		// Row events are encoded once, which tells their size.
+		if b, err := json.Marshal(data); err == nil {
+			e.data = json.RawMessage(b)
+			e.size = len(b)
+			j.rowBytes += len(b)
+		}
+	}
+	j.events = append(j.events, e)
+	j.trim(maxReplayBytes)
+	j.notify()
+}
+
+// trim drops the data of the oldest row events until the rest fit in limit bytes.
+func (j *job) trim(limit int) {
+	for ; j.rowBytes > limit && j.trimmed < len(j.events); j.trimmed++ {
+		e := &j.events[j.trimmed]
+		if e.size > 0 {
+			j.rowBytes -= e.size
+			e.data, e.size, e.dropped = nil, 0, true
+			j.dropped++
+		}
+	}
+}
+
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case err == context.Canceled:
+		j.status = jobCanceled
+	case err != nil:
+		j.status = jobFailed
+		j.err = err
+	default:
+		j.status = jobDone
+	}
+	j.finished = time.Now()
+	j.trim(maxFinishedReplayBytes)
+	j.notify()
+}
+
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// eventsAfter returns the events following the first n events, whether the job
+// is finished, and a channel that's closed when either changes.
+func (j *job) eventsAfter(n int) ([]jobEvent, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var events []jobEvent
+	if n < len(j.events) {
+		events = j.events[n:len(j.events):len(j.events)]
+	}
+	return events, j.status != jobRunning, j.changed
+}
+
+type jobInfo struct {
+	ID       string
+	Kind     string
+	Status   jobStatus
+	Error    *string
+	Progress jobProgress
+
+	// Backup is the path of the backup of a replace job.
+	Backup   *string
+	Created  time.Time
+	Finished *time.Time
+
+	// DroppedEvents is the number of row events no longer replayed
+	// to bound memory.
+	DroppedEvents int
+}
+
+func (j *job) info() jobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := jobInfo{
+		ID:       j.id,
+		Kind:     j.kind,
+		Status:   j.status,
+		Progress: j.progress,
+		Created:  j.created,
+
+		DroppedEvents: j.dropped,
+	}
+	if j.err != nil {
+		s := j.err.Error()
+		info.Error = &s
+	}
+	if j.backup != "" {
+		backup := j.backup
+		info.Backup = &backup
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		info.Finished = &finished
+	}
+	return info
+}
+
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs []*job
+}
+
+func (r *jobRegistry) add(j *job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Forget the oldest finished jobs.
+	finished := 0
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		if r.jobs[i].info().Status == jobRunning {
+			continue
+		}
+		finished++
+		if finished >= maxFinishedJobs {
+			r.jobs = append(r.jobs[:i], r.jobs[i+1:]...)
+		}
+	}
+	r.jobs = append(r.jobs, j)
+}
+
+func (r *jobRegistry) get(id string) *job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, j := range r.jobs {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (r *jobRegistry) list() []*job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*job(nil), r.jobs...)
+}
+
+// sessionJobs returns the jobs of a session.
+func (r *jobRegistry) sessionJobs(ss *session) []*job {
+	var jobs []*job
+	for _, j := range r.list() {
+		if j.session == ss {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+// running reports whether a session has running jobs.
+func (r *jobRegistry) running(ss *session) bool {
+	for _, j := range r.sessionJobs(ss) {
+		if j.info().Status == jobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+type jobReq struct {
+	Kind    string
+	Options json.RawMessage
+
+	// Journal records the values replaced by a replace job for rollback.
+	Journal bool
+
+	// Backup dumps the tables of a replace job before replacing.
+	Backup bool
+
+	// Rows, if set, restricts a replace job to the given rows of each table,
+	// such as rows ticked in search results.
+	Rows map[string][]splace.SeenRow
+
+	// Mapping, if set, is the CSV text of a mapping, or TSV with MappingTSV,
+	// replaced by a replace or preview job in place of the search, skipping
+	// the first line with MappingHeader.
+	Mapping       string
+	MappingTSV    bool
+	MappingHeader bool
+}
+
+// mapping reads the mapping of a request into the options of a replace or preview job.
+func (req jobReq) mapping(options *splace.ReplaceOptions) error {
+	if req.Mapping == "" {
+		return nil
+	}
+	m, err := splace.ReadMapping(strings.NewReader(req.Mapping), req.MappingTSV, req.MappingHeader)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "mapping: "+err.Error())
+	}
+	options.Mapping = m
+	return nil
+}
+
+// startJob starts a job of a session in the background.
+func (s *Server) startJob(ss *session, req jobReq) (*job, error) {
+	sp := ss.splace
+
+	var run func(ctx context.Context, out eventSender) error
+	switch req.Kind {
+	case "search":
+		var options splace.SearchOptions
+		if err := json.Unmarshal(req.Options, &options); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		run = func(ctx context.Context, out eventSender) error {
+			return s.runSearch(ctx, sp, options, out)
+		}
+	case "replace":
+		var options splace.ReplaceOptions
+		if err := json.Unmarshal(req.Options, &options); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := req.mapping(&options); err != nil {
+			return nil, err
+		}
+		run = func(ctx context.Context, out eventSender) error {
+			return s.runReplace(ctx, ss, options, req, out)
+		}
+	case "preview":
+		var options splace.ReplaceOptions
+		if err := json.Unmarshal(req.Options, &options); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := req.mapping(&options); err != nil {
+			return nil, err
+		}
+		run = func(ctx context.Context, out eventSender) error {
+			return s.runPreview(ctx, sp, options, out)
+		}
+	default:
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "unknown job kind")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		id:      uuid.NewV4().String(),
+		kind:    req.Kind,
+		session: ss,
+		created: time.Now(),
+		cancel:  cancel,
+		status:  jobRunning,
+		changed: make(chan struct{}),
+	}
+	j.Send("job", struct {
+		ID   string
+		Kind string
+	}{
+		ID:   j.id,
+		Kind: j.kind,
+	})
+	s.jobs.add(j)
+
+	go func() {
+		defer cancel()
+		err := run(ctx, j)
+		// Searches end without an error when they're canceled.
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		j.finish(err)
+	}()
+	return j, nil
+}
+
+func (s *Server) createJob(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	var req jobReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	j, err := s.startJob(ss, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, j.info())
+}
+
+func (s *Server) listJobs(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	resp := []jobInfo{}
+	for _, j := range s.jobs.sessionJobs(ss) {
+		resp = append(resp, j.info())
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// job returns the job of the request's session with the ID in the path.
+func (s *Server) job(c echo.Context) (*job, error) {
+	ss, err := s.session(c)
+	if err != nil {
+		return nil, err
+	}
+	j := s.jobs.get(c.Param("id"))
+	if j == nil || j.session != ss {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "job not found")
+	}
+	return j, nil
+}
+
+func (s *Server) getJob(c echo.Context) error {
+	j, err := s.job(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, j.info())
+}
+
+func (s *Server) cancelJob(c echo.Context) error {
+	j, err := s.job(c)
+	if err != nil {
+		return err
+	}
+	j.cancel()
+	return c.JSON(http.StatusOK, j.info())
+}
+
+type cancelReq struct {
+	ID string
+}
+
+// cancel cancels the job with the given ID, or every job of the session without one.
+func (s *Server) cancel(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	var req cancelReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	jobs := s.jobs.sessionJobs(ss)
+	if req.ID != "" {
+		j := s.jobs.get(req.ID)
+		if j == nil || j.session != ss {
+			return echo.NewHTTPError(http.StatusNotFound, "job not found")
+		}
+		jobs = []*job{j}
+	}
+	for _, j := range jobs {
+		j.cancel()
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// jobEvents streams the events of a job, starting after the event in the
+// Last-Event-ID header or lastEventId query parameter, until the job finishes.
+func (s *Server) jobEvents(c echo.Context) error {
+	j, err := s.job(c)
+	if err != nil {
+		return err
+	}
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("lastEventId")
+	}
+	after := 0
+	if lastEventID != "" {
+		after, err = strconv.Atoi(lastEventID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid event ID")
+		}
+	}
+	return s.attachJob(c, j, after)
+}
+
+// attachJob streams the events of a job following the first n events.
+func (s *Server) attachJob(c echo.Context, j *job, n int) error {
+	stream := sse.Open(c.Response().Writer)
+	defer stream.Close()
+
+	for {
+		events, finished, changed := j.eventsAfter(n)
+		for _, e := range events {
+			n++
+			if e.dropped {
+				continue
+			}
+			stream.SendID(strconv.Itoa(n), e.name, e.data)
+		}
+		// A finished job has no events left to wait for.
+		if finished {
+			return stream.Close()
+		}
+		select {
+		case <-changed:
+		case err := <-stream.Err():
+			return err
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zippoxer/splace/splace"
+
+	"github.com/labstack/echo"
+)
+
+const journalExt = ".jsonl.gz"
+
+func (s *Server) journalDir() (string, error) {
+	dir := s.opt.JournalDir
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cache, "splace", "journals")
+	}
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// createJournal creates the journal file of a replace job or a cell edit.
+func (s *Server) createJournal() (id string, f *os.File, err error) {
+	dir, err := s.journalDir()
+	if err != nil {
+		return "", nil, err
+	}
+	id = uuid.NewV4().String()
+	f, err = os.OpenFile(filepath.Join(dir, id+journalExt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	return id, f, err
+}
+
+type journalInfo struct {
+	ID      string
+	Created time.Time
+	Size    int64
+}
+
+func (s *Server) journals(c echo.Context) error {
+	dir, err := s.journalDir()
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	resp := []journalInfo{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), journalExt) {
+			continue
+		}
+		resp = append(resp, journalInfo{
+			ID:      strings.TrimSuffix(f.Name(), journalExt),
+			Created: f.ModTime(),
+			Size:    f.Size(),
+		})
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Created.After(resp[j].Created)
+	})
+	return c.JSON(http.StatusOK, resp)
+}
+
+type rollbackReq struct {
+	ID string
+}
+
+func (s *Server) rollback(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	var req rollbackReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	// IDs are UUIDs, which also keeps them from escaping the journal directory.
+	if _, err := uuid.FromString(req.ID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid journal ID")
+	}
+	dir, err := s.journalDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(dir, req.ID+journalExt))
+	if os.IsNotExist(err) {
+		return echo.NewHTTPError(http.StatusNotFound, "journal not found")
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var result splace.RollbackResult
+	result, err = ss.splace.Rollback(c.Request().Context(), f)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
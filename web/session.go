@@ -0,0 +1,156 @@
+package web
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zippoxer/splace/splace"
+	"github.com/zippoxer/splace/splace/querier"
+
+	"github.com/labstack/echo"
+)
+
+// sessionHeader carries the session ID of a request. Requests that can't
+// set headers, such as EventSource, send it in the session query parameter.
+const sessionHeader = "X-Splace-Session"
+
+const defaultSessionTimeout = 30 * time.Minute
+
+// session is a database connection made by a client.
+type session struct {
+	id     string
+	req    connectReq
+	db     querier.Querier
+	splace *splace.Splace
+
+	mu       sync.Mutex
+	lastUsed time.Time
+}
+
+func (ss *session) touch() {
+	ss.mu.Lock()
+	ss.lastUsed = time.Now()
+	ss.mu.Unlock()
+}
+
+func (ss *session) idle() time.Duration {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return time.Since(ss.lastUsed)
+}
+
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func (r *sessionRegistry) add(ss *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = map[string]*session{}
+	}
+	r.sessions[ss.id] = ss
+}
+
+func (r *sessionRegistry) get(id string) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[id]
+}
+
+func (r *sessionRegistry) remove(id string) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ss := r.sessions[id]
+	delete(r.sessions, id)
+	return ss
+}
+
+func (r *sessionRegistry) list() []*session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sessions []*session
+	for _, ss := range r.sessions {
+		sessions = append(sessions, ss)
+	}
+	return sessions
+}
+
+func (s *Server) newSession(req connectReq, db querier.Querier) *session {
+	ss := &session{
+		id:       uuid.NewV4().String(),
+		req:      req,
+		db:       db,
+		splace:   splace.New(db),
+		lastUsed: time.Now(),
+	}
+	s.sessions.add(ss)
+	return ss
+}
+
+func sessionID(c echo.Context) string {
+	if id := c.Request().Header.Get(sessionHeader); id != "" {
+		return id
+	}
+	return c.QueryParam("session")
+}
+
+// session returns the session of a request.
+func (s *Server) session(c echo.Context) (*session, error) {
+	ss := s.sessions.get(sessionID(c))
+	if ss == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "not connected")
+	}
+	ss.touch()
+	return ss, nil
+}
+
+// closeSession cancels the jobs of a session and closes its connection.
+func (s *Server) closeSession(id string) error {
+	ss := s.sessions.remove(id)
+	if ss == nil {
+		return nil
+	}
+	for _, j := range s.jobs.list() {
+		if j.session == ss {
+			j.cancel()
+		}
+	}
+	return ss.db.Close()
+}
+
+func (s *Server) disconnect(c echo.Context) error {
+	if err := s.closeSession(sessionID(c)); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (s *Server) sessionTimeout() time.Duration {
+	if s.opt.SessionTimeout > 0 {
+		return s.opt.SessionTimeout
+	}
+	return defaultSessionTimeout
+}
+
+// expireSessions closes sessions that have been idle for longer than
+// the session timeout and have no running jobs.
+func (s *Server) expireSessions() {
+	timeout := s.sessionTimeout()
+	interval := time.Minute
+	if timeout/2 < interval {
+		interval = timeout / 2
+	}
+	for range time.Tick(interval) {
+		for _, ss := range s.sessions.list() {
+			if ss.idle() < timeout || s.jobs.running(ss) {
+				continue
+			}
+			s.closeSession(ss.id)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 )
 
 type event struct {
+	id   string
 	typ  string
 	data interface{}
 }
@@ -16,7 +17,12 @@ type Stream struct {
 	flusher http.Flusher
 	event   chan event
 	err     chan error
+	done    chan struct{}
 	closed  bool
+
+	// writeErr is the first error writing to w, after which
+	// events are discarded so Send never blocks.
+	writeErr error
 }
 
 func Open(w http.ResponseWriter) *Stream {
@@ -29,38 +35,60 @@ func Open(w http.ResponseWriter) *Stream {
 		w:       w,
 		flusher: flusher,
 		event:   make(chan event, 128),
-		err:     make(chan error),
+		err:     make(chan error, 1),
+		done:    make(chan struct{}),
 	}
 	go s.encoder()
 	return s
 }
 
 func (s *Stream) encoder() {
-	defer close(s.err)
-	var err error
+	defer close(s.done)
 	enc := json.NewEncoder(s.w)
 	for e := range s.event {
-		_, err = fmt.Fprintf(s.w, "event: %s\ndata: ", e.typ)
-		if err != nil {
-			break
-		}
-		err = enc.Encode(e.data)
-		if err != nil {
-			break
+		if s.writeErr != nil {
+			continue
 		}
-		_, err = fmt.Fprint(s.w, "\n\n")
-		if err != nil {
-			break
+		if err := s.write(enc, e); err != nil {
+			s.writeErr = err
+			s.err <- err
 		}
-		if s.flusher != nil {
-			s.flusher.Flush()
+	}
+}
+
+func (s *Stream) write(enc *json.Encoder, e event) error {
+	if e.id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", e.id); err != nil {
+			return err
 		}
 	}
-	s.err <- err
+	_, err := fmt.Fprintf(s.w, "event: %s\ndata: ", e.typ)
+	if err != nil {
+		return err
+	}
+	err = enc.Encode(e.data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(s.w, "\n\n")
+	if err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
 }
 
 func (s *Stream) Send(ev string, data interface{}) {
+	s.SendID("", ev, data)
+}
+
+// SendID sends an event with an ID, which the client sends back in the
+// Last-Event-ID header when it reconnects.
+func (s *Stream) SendID(id string, ev string, data interface{}) {
 	s.event <- event{
+		id:   id,
 		typ:  ev,
 		data: data,
 	}
@@ -72,9 +100,11 @@ func (s *Stream) Close() error {
 	}
 	s.closed = true
 	close(s.event)
-	return <-s.err
+	<-s.done
+	return s.writeErr
 }
 
+// Err receives the error that broke the stream, such as the client disconnecting.
 func (s *Stream) Err() <-chan error {
 	return s.err
 }
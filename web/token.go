@@ -0,0 +1,38 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// tokenHeader carries the access token of the server, which the app receives
+// in index.html. GET requests that can't set headers, such as EventSource and
+// download links, send it in the token query parameter instead.
+const tokenHeader = "X-Splace-Token"
+
+func newToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requireToken rejects requests without the access token of the server,
+// so other local processes and web pages can't use the API.
+func (s *Server) requireToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.Request().Header.Get(tokenHeader)
+		if token == "" && c.Request().Method == http.MethodGet {
+			token = c.QueryParam("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			return echo.NewHTTPError(http.StatusForbidden, "invalid token")
+		}
+		return next(c)
+	}
+}
@@ -3,6 +3,7 @@ package web
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
@@ -12,7 +13,10 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,7 +24,6 @@ import (
 
 	"github.com/zippoxer/splace/splace"
 	"github.com/zippoxer/splace/splace/querier"
-	"github.com/zippoxer/splace/web/sse"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -30,23 +33,39 @@ type Options struct {
 	Path  string
 	Debug bool
 	Addr  string
+
+	// JournalDir is where the journals of replace jobs are kept.
+	// Defaults to a directory in the user's cache directory.
+	JournalDir string
+
+	// BackupDir is where replace jobs back up the tables they replace in.
+	// Defaults to a directory in the user's cache directory.
+	BackupDir string
+
+	// SessionTimeout is how long a connection may sit idle before it's closed.
+	// Defaults to 30 minutes.
+	SessionTimeout time.Duration
 }
 
 type Server struct {
 	opt Options
 
-	addr   net.Addr
-	db     querier.Querier
-	splace *splace.Splace
+	addr     net.Addr
+	sessions sessionRegistry
+	jobs     jobRegistry
 
 	// Secret is a cryptographically generated random for this session.
 	secret string
+
+	// token is required by every API request.
+	token string
 }
 
 func New(opt Options) *Server {
 	return &Server{
 		opt:    opt,
 		secret: uuid.NewV4().String(),
+		token:  newToken(),
 	}
 }
 
@@ -68,7 +87,7 @@ func (s *Server) Run() error {
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"http://localhost:8080", "http://" + s.addr.String()},
 		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, sessionHeader, tokenHeader},
 	}))
 
 	templateFile := "web/app/dist/index.html"
@@ -84,11 +103,31 @@ func (s *Server) Run() error {
 
 	e.Static("/static", filepath.Join(s.opt.Path, "web/app/dist/static"))
 	e.GET("/", s.index)
-	e.POST("/connect", s.connect)
-	e.GET("/search", s.search)
-	e.GET("/replace", s.replace)
-	e.GET("/dump", s.dump)
-	e.GET("/download-php-proxy", s.downloadPhpProxy)
+
+	// Replaces are started with POST /jobs, since they change data.
+	api := e.Group("", s.requireToken)
+	api.POST("/connect", s.connect)
+	api.POST("/disconnect", s.disconnect)
+	api.GET("/schemas", s.schemas)
+	api.GET("/search", s.search)
+	api.GET("/preview", s.preview)
+	api.GET("/value", s.value)
+	api.POST("/cell", s.updateCell)
+	api.GET("/journals", s.journals)
+	api.POST("/rollback", s.rollback)
+	api.GET("/backups", s.backups)
+	api.POST("/restore", s.restore)
+	api.POST("/upload", s.upload)
+	api.POST("/jobs", s.createJob)
+	api.GET("/jobs", s.listJobs)
+	api.GET("/jobs/:id", s.getJob)
+	api.GET("/jobs/:id/events", s.jobEvents)
+	api.POST("/jobs/:id/cancel", s.cancelJob)
+	api.POST("/cancel", s.cancel)
+	api.GET("/dump", s.dump)
+	api.GET("/download-php-proxy", s.downloadPhpProxy)
+
+	go s.expireSessions()
 
 	return http.Serve(ln, e)
 }
@@ -120,7 +159,8 @@ func (s *Server) index(c echo.Context) error {
 	}
 	return c.Render(http.StatusOK, "index.html", map[string]interface{}{
 		"Dev":       s.debug(),
-		"APIURL":    s.addr.String(),
+		"APIURL":    "http://" + s.addr.String(),
+		"Token":     s.token,
 		"BundleURL": bundleURL,
 	})
 }
@@ -133,8 +173,13 @@ type connectReq struct {
 	Database string
 	User     string
 	Pwd      string
+	Params   map[string]string
 
 	URL string
+
+	// Schemas, if set, are the schemas to work across, as names or
+	// patterns such as tenant_*. Tables are then named schema.table.
+	Schemas []string
 }
 
 type discoveredConfig struct {
@@ -144,6 +189,9 @@ type discoveredConfig struct {
 }
 
 type connectResp struct {
+	// Session identifies the connection in later requests.
+	Session string
+
 	Tables            splace.TableMap
 	DiscoveredConfigs []discoveredConfig
 	Error             string
@@ -155,39 +203,38 @@ func (s *Server) connect(c echo.Context) error {
 		return err
 	}
 
-	config := querier.Config{
-		Engine:   req.Engine,
-		Addr:     req.Host,
-		Database: req.Database,
-		User:     req.User,
-		Pwd:      req.Pwd,
-	}
-	switch req.Driver {
-	case "direct":
-		var err error
-		s.db, err = querier.NewDirect(config)
+	ss := s.sessions.get(sessionID(c))
+	if ss == nil || !reflect.DeepEqual(ss.req, req) {
+		db, err := s.open(req)
 		if err != nil {
 			return err
 		}
-	case "php":
-		qr, err := querier.NewPHP(req.URL, s.secret, config)
-		if err != nil {
-			return err
+		// The previous connection of the session is closed, unless
+		// it's still busy, in which case it expires when idle.
+		if ss != nil && !s.jobs.running(ss) {
+			if err := s.closeSession(ss.id); err != nil {
+				c.Logger().Error(err)
+			}
 		}
-		s.db = qr
+		ss = s.newSession(req, db)
 	}
+	ss.touch()
 
-	s.splace = splace.New(s.db)
-
-	var resp connectResp
+	resp := connectResp{
+		Session: ss.id,
+	}
 
 	var err error
-	resp.Tables, err = s.splace.Tables(c.Request().Context())
+	if len(req.Schemas) > 0 {
+		resp.Tables, err = ss.splace.SchemaTables(c.Request().Context(), req.Schemas)
+	} else {
+		resp.Tables, err = ss.splace.Tables(c.Request().Context())
+	}
 	if err != nil {
 		resp.Error = err.Error()
 	}
 
-	for _, c := range s.db.DiscoveredConfigs() {
+	for _, c := range ss.db.DiscoveredConfigs() {
 		resp.DiscoveredConfigs = append(resp.DiscoveredConfigs, discoveredConfig{
 			Who:   c.Who,
 			Where: c.Where,
@@ -200,6 +247,7 @@ func (s *Server) connect(c echo.Context) error {
 				User:     c.Config.User,
 				Pwd:      c.Config.Pwd,
 				Database: c.Config.Database,
+				Params:   c.Config.Params,
 			},
 		})
 	}
@@ -207,17 +255,59 @@ func (s *Server) connect(c echo.Context) error {
 	return c.JSON(http.StatusOK, resp)
 }
 
+// schemas lists the schemas of the server of the session.
+func (s *Server) schemas(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	schemas, err := ss.splace.Schemas(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	if schemas == nil {
+		schemas = []string{}
+	}
+	return c.JSON(http.StatusOK, schemas)
+}
+
+// open connects to the database of a connect request.
+func (s *Server) open(req connectReq) (querier.Querier, error) {
+	config := querier.Config{
+		Engine:   req.Engine,
+		Addr:     req.Host,
+		Database: req.Database,
+		User:     req.User,
+		Pwd:      req.Pwd,
+		Params:   req.Params,
+	}
+	switch req.Driver {
+	case "direct":
+		return querier.NewDirect(config)
+	case "php":
+		return querier.NewPHP(req.URL, s.secret, config)
+	}
+	return nil, echo.NewHTTPError(http.StatusBadRequest, "unknown driver")
+}
+
 func (s *Server) dump(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
 	filename := fmt.Sprintf("%s--%s.sql.gz",
-		s.db.Config().Database,
-		time.Now().Format("2006-02-01--15-04-05"))
+		ss.db.Config().Database,
+		time.Now().Format("2006-01-02--15-04-05"))
 	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
 	c.Response().Header().Set("Content-Type", "application/sql")
 
 	w := gzip.NewWriter(c.Response().Writer)
 	defer w.Close()
 
-	err := s.db.Dump(c.Request().Context(), w)
+	err = ss.db.Dump(c.Request().Context(), w, querier.DumpOptions{
+		Tables:        splitList(c.QueryParam("tables")),
+		ExcludeTables: splitList(c.QueryParam("excludeTables")),
+	})
 	if err != nil {
 		return err
 	}
@@ -225,6 +315,14 @@ func (s *Server) dump(c echo.Context) error {
 	return w.Flush()
 }
 
+// splitList splits a comma separated query parameter.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
 func (s *Server) downloadPhpProxy(c echo.Context) error {
 	data, err := ioutil.ReadFile(filepath.Join("data", "splace-proxy.php"))
 	if err != nil {
@@ -243,50 +341,100 @@ func (s *Server) downloadPhpProxy(c echo.Context) error {
 	return err
 }
 
+// eventSender receives the events of a job.
+type eventSender interface {
+	Send(ev string, data interface{})
+}
+
+// search and preview start a job and stream its events,
+// for clients that don't need to reattach.
 func (s *Server) search(c echo.Context) error {
-	var options splace.SearchOptions
+	return s.startAndAttach(c, "search")
+}
 
-	if err := json.Unmarshal([]byte(c.QueryParam("options")), &options); err != nil {
+func (s *Server) preview(c echo.Context) error {
+	return s.startAndAttach(c, "preview")
+}
+
+func (s *Server) startAndAttach(c echo.Context, kind string) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	j, err := s.startJob(ss, jobReq{
+		Kind:    kind,
+		Options: json.RawMessage(c.QueryParam("options")),
+	})
+	if err != nil {
 		return err
 	}
+	return s.attachJob(c, j, 0)
+}
 
-	searcher := s.splace.Search(c.Request().Context(), options)
-	stream := sse.Open(c.Response().Writer)
-	defer stream.Close()
+func (s *Server) runSearch(ctx context.Context, sp *splace.Splace, options splace.SearchOptions, stream eventSender) error {
+	searcher := sp.Search(ctx, options)
 
-	var wg sync.WaitGroup
-	lastSendRows := time.Now()
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		lastSendRows = time.Now()
+	)
 	for {
 		select {
 		case result := <-searcher.Results():
 			stream.Send("table", struct {
-				Table string
-				SQL   string
-				Start time.Time
+				Table   string
+				Schema  string
+				Object  string
+				SQL     string
+				Columns []string
+				Key     []string
+				Count   *splace.SearchCount
+				Start   time.Time
 			}{
-				Table: result.Table,
-				SQL:   result.SQL,
-				Start: result.Start,
+				Table:   result.Table,
+				Schema:  result.Schema,
+				Object:  result.Object,
+				SQL:     result.SQL,
+				Columns: result.Columns,
+				Key:     result.Key,
+				Count:   result.Count,
+				Start:   result.Start,
 			})
 
 			wg.Add(1)
 			go func(result splace.SearchResult) {
 				defer wg.Done()
 
+				if result.Matches != nil {
+					sendMatches(stream, result)
+					return
+				}
+
 				buffLimit := 500
 				buff := make([][]string, buffLimit)
 				buffPos := 0
 				buffSize := 0
+				sinceLastSend := func() time.Duration {
+					mu.Lock()
+					defer mu.Unlock()
+					return time.Since(lastSendRows)
+				}
+				sent := func() {
+					mu.Lock()
+					lastSendRows = time.Now()
+					mu.Unlock()
+				}
 				sendRows := func() {
 					if buffPos > 0 {
 						stream.Send("rows", []interface{}{
 							result.Table,
 							buffPos,
-							buff[:buffPos],
+							append([][]string(nil), buff[:buffPos]...),
 						})
 						buffPos = 0
 						buffSize = 0
-						lastSendRows = time.Now()
+						sent()
 					}
 				}
 
@@ -298,13 +446,13 @@ func (s *Server) search(c echo.Context) error {
 							rowCount,
 						})
 						rowCount = 0
-						lastSendRows = time.Now()
+						sent()
 					}
 				}
 				for row := range result.Rows {
 					if buffPos == buffLimit {
 						rowCount++
-						if time.Since(lastSendRows) > time.Millisecond*200 {
+						if sinceLastSend() > time.Millisecond*200 {
 							sendRowCount()
 						}
 						continue
@@ -320,7 +468,7 @@ func (s *Server) search(c echo.Context) error {
 					// If rows buffer is full or the update interval
 					// has passed, send the rows.
 					if buffSize >= 128*1024 ||
-						time.Since(lastSendRows) > time.Millisecond*200 {
+						sinceLastSend() > time.Millisecond*200 {
 						sendRows()
 					}
 				}
@@ -330,48 +478,190 @@ func (s *Server) search(c echo.Context) error {
 
 		case err := <-searcher.Done():
 			wg.Wait()
+			sendDone(stream, err, nil)
+			return err
+		}
+	}
+}
 
-			var msg struct {
-				Error *string
-			}
-			if err != nil {
-				s := err.Error()
-				msg.Error = &s
-			}
-			stream.Send("done", msg)
+// sendMatches sends the matches of a table with snippets in batches,
+// which stay small since they don't hold whole values.
+func sendMatches(stream eventSender, result splace.SearchResult) {
+	const batchLimit = 500
+	var batch []splace.MatchRow
+	lastSend := time.Now()
+	send := func() {
+		if len(batch) > 0 {
+			stream.Send("matches", []interface{}{result.Table, batch})
+			batch = nil
+		}
+		lastSend = time.Now()
+	}
+	for m := range result.Matches {
+		batch = append(batch, m)
+		if len(batch) == batchLimit || time.Since(lastSend) > time.Millisecond*200 {
+			send()
+		}
+	}
+	send()
+}
 
-			return stream.Close()
+type updateCellReq struct {
+	Table     string
+	Column    string
+	Key       []string
+	KeyValues []string
 
-		case err := <-stream.Err():
-			wg.Wait()
+	// Old is the value the cell was seen with, and Value its new value.
+	Old   string
+	Value string
+
+	// Journal records the edit in a journal of its own, whose ID is returned,
+	// so it can be rolled back like a replace job.
+	Journal bool
+}
+
+// updateCell edits a single cell of a row by hand, as long as
+// it still holds the value it was seen with.
+func (s *Server) updateCell(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	var req updateCellReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	var (
+		journalID   string
+		journalFile *os.File
+		journal     *splace.JournalWriter
+	)
+	if req.Journal {
+		journalID, journalFile, err = s.createJournal()
+		if err != nil {
 			return err
 		}
+		defer journalFile.Close()
+		journal = splace.NewJournalWriter(journalFile)
 	}
+	err = ss.splace.UpdateCell(c.Request().Context(), req.Table, req.Column, req.Key, req.KeyValues, req.Old, req.Value, journal)
+	if journal != nil {
+		if cerr := journal.Close(); err == nil {
+			err = cerr
+		}
+		// An edit that failed has nothing to roll back.
+		if err != nil {
+			journalFile.Close()
+			os.Remove(journalFile.Name())
+		}
+	}
+	switch err {
+	case nil:
+		if journal != nil {
+			return c.JSON(http.StatusOK, struct {
+				Journal string
+			}{journalID})
+		}
+		return c.NoContent(http.StatusOK)
+	case splace.ErrConflict:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case splace.ErrRowNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return err
 }
 
-func (s *Server) replace(c echo.Context) error {
-	var options splace.ReplaceOptions
-
-	if err := json.Unmarshal([]byte(c.QueryParam("options")), &options); err != nil {
+// value returns the whole value of a column in a row, by the key of the row,
+// for matches sent with snippets.
+func (s *Server) value(c echo.Context) error {
+	ss, err := s.session(c)
+	if err != nil {
+		return err
+	}
+	params := c.QueryParams()
+	value, err := ss.splace.Value(c.Request().Context(),
+		c.QueryParam("table"), c.QueryParam("column"), params["key"], params["value"])
+	if err == splace.ErrRowNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
 		return err
 	}
+	return c.JSON(http.StatusOK, struct {
+		Value string
+	}{value})
+}
+
+func (s *Server) runReplace(ctx context.Context, ss *session, options splace.ReplaceOptions, req jobReq, stream eventSender) error {
+	// A journal records the replaced values so the job can be rolled back.
+	if req.Journal {
+		id, f, err := s.createJournal()
+		if err != nil {
+			sendDone(stream, err, nil)
+			return err
+		}
+		defer f.Close()
+		options.Journal = splace.NewJournalWriter(f)
+		// Closed once the job is done; the deferred Close covers the early returns.
+		defer options.Journal.Close()
+
+		stream.Send("journal", struct {
+			ID string
+		}{
+			ID: id,
+		})
+	}
 
-	replacer := s.splace.Replace(c.Request().Context(), options)
-	stream := sse.Open(c.Response().Writer)
-	defer stream.Close()
+	// A backup of the tables lets the job be reverted as a whole.
+	var (
+		backup   *os.File
+		backupGz *gzip.Writer
+	)
+	if req.Backup {
+		id, path, f, err := s.createBackup(ss.db.Config().Database)
+		if err != nil {
+			sendDone(stream, err, nil)
+			return err
+		}
+		defer f.Close()
+		backup = f
+		backupGz = gzip.NewWriter(f)
+		options.Backup = backupGz
+
+		stream.Send("backup", backupEvent{
+			ID:   id,
+			Path: path,
+		})
+	}
+
+	var replacer *splace.Replacer
+	if req.Rows != nil {
+		replacer = ss.splace.ReplaceInRows(ctx, options, req.Rows)
+	} else {
+		replacer = ss.splace.Replace(ctx, options)
+	}
 
 	var wg sync.WaitGroup
 	for {
 		select {
 		case result := <-replacer.Results():
 			stream.Send("table", struct {
-				Table string
-				SQL   string
-				Start time.Time
+				Table     string
+				Schema    string
+				Object    string
+				SQL       string
+				Skipped   []splace.SkippedColumn
+				Conflicts [][]string
+				Start     time.Time
 			}{
-				Table: result.Table,
-				SQL:   result.SQL,
-				Start: result.Start,
+				Table:     result.Table,
+				Schema:    result.Schema,
+				Object:    result.Object,
+				SQL:       result.SQL,
+				Skipped:   result.Skipped,
+				Conflicts: result.Conflicts,
+				Start:     result.Start,
 			})
 
 			wg.Add(1)
@@ -384,25 +674,126 @@ func (s *Server) replace(c echo.Context) error {
 
 		case err := <-replacer.Done():
 			wg.Wait()
-
-			var msg struct {
-				Error *string
+			// The journal is complete before the job is reported done,
+			// so it can be rolled back right away.
+			if options.Journal != nil {
+				if cerr := options.Journal.Close(); err == nil {
+					err = cerr
+				}
 			}
-			if err != nil {
-				s := err.Error()
-				msg.Error = &s
+			if backup != nil {
+				// The backup is complete by the time the job is done.
+				if cerr := backupGz.Close(); err == nil {
+					err = cerr
+				}
+				// A replace that failed before its backup was complete
+				// has nothing to revert.
+				if err != nil && !replacer.BackedUp() {
+					backup.Close()
+					os.Remove(backup.Name())
+				}
+			}
+			if err == nil && options.Mapping != nil {
+				sendHits(stream, options.Mapping)
 			}
-			stream.Send("done", msg)
+			sendDone(stream, err, nil)
+			return err
+		}
+	}
+}
+
+type backupEvent struct {
+	ID   string
+	Path string
+}
+
+func (s *Server) runPreview(ctx context.Context, sp *splace.Splace, options splace.ReplaceOptions, stream eventSender) error {
+	previewer := sp.Preview(ctx, options)
+
+	var wg sync.WaitGroup
+	for {
+		select {
+		case result := <-previewer.Results():
+			stream.Send("table", struct {
+				Table  string
+				Schema string
+				SQL    string
+				Key    []string
+				Start  time.Time
+			}{
+				Table:  result.Table,
+				Schema: result.Schema,
+				SQL:    result.SQL,
+				Key:    result.Key,
+				Start:  result.Start,
+			})
 
-			return stream.Close()
+			wg.Add(1)
+			go func(result splace.PreviewResult) {
+				defer wg.Done()
 
-		case err := <-stream.Err():
+				var buff []splace.PreviewRow
+				lastSend := time.Now()
+				sendRows := func() {
+					if len(buff) > 0 {
+						stream.Send("rows", []interface{}{result.Table, buff})
+						buff = nil
+						lastSend = time.Now()
+					}
+				}
+				for row := range result.Rows {
+					buff = append(buff, row)
+					if len(buff) >= 100 || time.Since(lastSend) > time.Millisecond*200 {
+						sendRows()
+					}
+				}
+				sendRows()
+			}(result)
+
+		case err := <-previewer.Done():
 			wg.Wait()
+			totals := previewer.Totals()
+			if err == nil && options.Mapping != nil {
+				sendHits(stream, options.Mapping)
+			}
+			sendDone(stream, err, &totals)
 			return err
 		}
 	}
 }
 
+// sendHits sends how many matches of each pair of a mapping were replaced,
+// as a list of the pairs with any.
+func sendHits(stream eventSender, m *splace.Mapping) {
+	type pairHits struct {
+		Search  string
+		Replace string
+		Hits    int64
+	}
+	hits := []pairHits{}
+	for i, n := range m.Hits() {
+		if n > 0 {
+			p := m.Pairs()[i]
+			hits = append(hits, pairHits{p.Search, p.Replace, n})
+		}
+	}
+	stream.Send("hits", hits)
+}
+
+// sendDone sends the last event of a job.
+func sendDone(stream eventSender, err error, totals *splace.PreviewTotals) {
+	var msg struct {
+		Error  *string
+		Totals *splace.PreviewTotals `json:",omitempty"`
+	}
+	if err != nil {
+		s := err.Error()
+		msg.Error = &s
+	}
+	msg.Totals = totals
+	stream.Send("done", msg)
+}
+
 type Template struct {
 	templates *template.Template
 }